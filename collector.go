@@ -0,0 +1,122 @@
+package main
+
+import (
+	"actiontec"
+	"config"
+	"context"
+	"fmt"
+	"health"
+	"log"
+	"sink"
+	"sync"
+	"time"
+)
+
+// Wraps up an actiontec.Context with the configuration needed to use it. The
+// Actiontec UI has global state (see GetStatus), so only one collection can be
+// in flight at a time: the ticker loop and anything scraping on demand share
+// one of these.
+type collector struct {
+	mu      sync.Mutex
+	ctx     *actiontec.Context
+	router  config.Router
+	monitor *health.Monitor
+}
+
+func newCollector(router config.Router) (*collector, error) {
+	// Originally, a context was created on each tick, but Go seemed to be unable
+	// to GC the open file descriptors for the HTTP client, which is unfortunate.
+	ctx, err := actiontec.NewContext(router.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.SetTimeouts(actiontec.Timeouts{
+		Dial:     time.Duration(router.DialTimeout),
+		Response: time.Duration(router.ResponseTimeout),
+	})
+
+	// Validation has already made sure the profile exists.
+	if router.Profile != "" {
+		ctx.SetProfile(actiontec.LookupProfile(router.Profile))
+	}
+
+	return &collector{
+		ctx:    ctx,
+		router: router,
+	}, nil
+}
+
+// Log in, gather a sample, and log out again. The whole thing is bounded by
+// ctx, and by the router's configured timeout.
+func (c *collector) Collect(ctx context.Context) (*sink.Sample, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.router.Timeout))
+	defer cancel()
+
+	// We'll re-login every time: it doesn't hurt, and the Actiontec UI seems to
+	// base the logout timeout on when you logged in, not your last activity.
+	start := time.Now()
+	err := c.ctx.LoginContext(ctx, c.router.Username, c.router.Password)
+	c.monitor.ObserveLogin(c.router.Name, time.Since(start), err)
+	if err != nil {
+		return nil, fmt.Errorf("Error logging into router: %w", err)
+	}
+
+	start = time.Now()
+	status, stats, err := c.ctx.GetStatusContext(ctx)
+	c.monitor.ObserveScrape(c.router.Name, time.Since(start), err)
+	if err != nil {
+		// We're still logged in, and ctx may well be why this failed, so logging
+		// out can't use it.
+		if lerr := c.logout(); lerr != nil {
+			log.Printf("[%s] Error logging out: %v", c.router.Name, lerr)
+		}
+		return nil, fmt.Errorf("Error getting stats from router: %w", err)
+	}
+
+	if err := c.ctx.LogoutContext(ctx); err != nil {
+		log.Printf("[%s] Error logging out (will attempt to continue): %v", c.router.Name, err)
+	}
+
+	sample := &sink.Sample{
+		Source: c.source(),
+		Time:   time.Now(),
+		Status: status,
+		Lines:  stats,
+	}
+	c.monitor.ObserveSample(sample)
+
+	return sample, nil
+}
+
+// Make sure we're logged out, for when a collection may have been interrupted
+// part way through. This doesn't take the context of whatever's shutting us
+// down, since that's likely to have been cancelled already.
+func (c *collector) Logout() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.logout()
+}
+
+// Must be called with the lock held.
+func (c *collector) logout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+	defer cancel()
+
+	return c.ctx.LogoutContext(ctx)
+}
+
+// Logging out is a nicety; it's not worth holding up shutdown for long.
+const logoutTimeout = 5 * time.Second
+
+func (c *collector) source() sink.Source {
+	return sink.Source{
+		Router: c.router.Name,
+		Host:   c.router.Host,
+		Tags:   c.router.Tags,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"config"
+	"context"
+	"fakerouter"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCollectorCollect(t *testing.T) {
+	frame, err := fakerouter.LoadFrame("src/actiontec/testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both lines in the fixture have the same rates, so the same payload will
+	// pass as either line.
+	server := httptest.NewServer(fakerouter.New("admin", "password", []string{frame[0], frame[0]}))
+	defer server.Close()
+
+	cfg := &config.Config{Routers: []config.Router{{
+		Name:     "test",
+		Host:     strings.TrimPrefix(server.URL, "http://"),
+		Password: "password",
+	}}}
+	cfg.SetDefaults()
+
+	coll, err := newCollector(cfg.Routers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample, err := coll.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if sample.Router != "test" {
+		t.Errorf("Invalid router: got %v", sample.Router)
+	}
+
+	if len(sample.Lines) != 2 {
+		t.Errorf("Unexpected number of lines: %d", len(sample.Lines))
+	}
+
+	if sample.Status.SoftwareVersion != "T2200H-31.128L.03" {
+		t.Errorf("Invalid software version: got %v", sample.Status.SoftwareVersion)
+	}
+
+	coll.router.Password = "wrong"
+	if _, err := coll.Collect(context.Background()); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
+
+// A collection that fails after logging in still logs out, so that it doesn't
+// leave a session open on the router.
+func TestCollectorCollectLogout(t *testing.T) {
+	router := fakerouter.New("admin", "password", []string{"garbage"})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	cfg := &config.Config{Routers: []config.Router{{
+		Name:     "test",
+		Host:     strings.TrimPrefix(server.URL, "http://"),
+		Password: "password",
+	}}}
+	cfg.SetDefaults()
+
+	coll, err := newCollector(cfg.Routers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := coll.Collect(context.Background()); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+
+	if router.LoggedIn("127.0.0.1") {
+		t.Errorf("Expected to be logged out")
+	}
+}
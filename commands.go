@@ -0,0 +1,371 @@
+package main
+
+import (
+	"actiontec"
+	"config"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"history"
+	"io"
+	"log"
+	"math"
+	"sink"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes for the one shot commands, so that scripts can tell a typo from a
+// modem that's down.
+const (
+	exitOK             = 0
+	exitError          = 1
+	exitUsage          = 2
+	exitBadCredentials = 3
+)
+
+// A one shot command: does its thing once against a single router, writes
+// whatever it has to say to w, and returns an exit code.
+type command func(coll *collector, w io.Writer) int
+
+var commands = map[string]command{
+	"check-login": checkLoginCommand,
+	"dump":        dumpCommand,
+	"status":      statusCommand,
+}
+
+// Commands that work from what's been collected already, rather than talking
+// to the routers. These get whatever arguments follow the command's name.
+type localCommand func(args []string, cfg *config.Config, w io.Writer) int
+
+var localCommands = map[string]localCommand{
+	"history": historyCommand,
+}
+
+const commandUsage = `
+Commands (instead of running as a daemon):
+  status       log in, print the modem and line stats, and exit
+  dump         log in, print the raw status payload for each line, and exit
+  check-login  check that the router accepts the user name and password
+  history      print stored history; see history -h
+
+Commands run against every configured router, and exit with 0 on success, 1 if
+a router couldn't be queried, 2 on a usage or configuration error, and 3 if a
+router rejected the credentials.
+`
+
+// Runs the named command against every configured router. The exit code is
+// the worst of them.
+func runCommand(name string, args []string, cfg *config.Config, w io.Writer) int {
+	if cmd, ok := localCommands[name]; ok {
+		return cmd(args, cfg, w)
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		log.Printf("Unknown command: %s", name)
+		return exitUsage
+	}
+	if len(args) > 0 {
+		log.Printf("%s doesn't take any arguments", name)
+		return exitUsage
+	}
+
+	if len(cfg.Routers) == 0 {
+		log.Print("At least one router must be configured, either with -host or in a configuration file.")
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, router := range cfg.Routers {
+		coll, err := newCollector(router)
+		if err != nil {
+			log.Printf("[%s] Error creating context: %v", router.Name, err)
+			return exitError
+		}
+
+		if len(cfg.Routers) > 1 {
+			fmt.Fprintf(w, "==> %s <==\n", router.Name)
+		}
+		if c := cmd(coll, w); c > code {
+			code = c
+		}
+	}
+
+	return code
+}
+
+func checkLoginCommand(coll *collector, w io.Writer) int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(coll.router.Timeout))
+	defer cancel()
+
+	if err := coll.ctx.LoginContext(ctx, coll.router.Username, coll.router.Password); err != nil {
+		return reportError(coll, err)
+	}
+
+	if err := coll.ctx.LogoutContext(ctx); err != nil {
+		log.Printf("[%s] Error logging out: %v", coll.router.Name, err)
+	}
+
+	fmt.Fprintf(w, "Logged in to %s as %s.\n", coll.router.Host, coll.router.Username)
+	return exitOK
+}
+
+func statusCommand(coll *collector, w io.Writer) int {
+	sample, err := coll.Collect(context.Background())
+	if err != nil {
+		return reportError(coll, err)
+	}
+
+	if err := writeStatus(w, sample); err != nil {
+		log.Printf("[%s] %v", coll.router.Name, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// The raw payloads are printed as they're received, so they're still there
+// even if ParseStatus can't make sense of them, which is usually why you'd
+// want them.
+func dumpCommand(coll *collector, w io.Writer) int {
+	coll.ctx.SetRecorder(&payloadWriter{w})
+
+	if _, err := coll.Collect(context.Background()); err != nil {
+		return reportError(coll, err)
+	}
+	return exitOK
+}
+
+func reportError(coll *collector, err error) int {
+	log.Printf("[%s] %v", coll.router.Name, err)
+	if errors.Is(err, actiontec.ErrBadCredentials) {
+		return exitBadCredentials
+	}
+	return exitError
+}
+
+// Writes each raw payload on a line of its own.
+type payloadWriter struct {
+	w io.Writer
+}
+
+func (p *payloadWriter) Record(line int, payload string) error {
+	_, err := fmt.Fprintln(p.w, payload)
+	return err
+}
+
+// Prints a sample as a couple of tables: one for the modem as a whole, and one
+// with a row per line.
+func writeStatus(w io.Writer, sample *sink.Sample) error {
+	s := sample.Status
+	e := &s.Errors
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Router:\t%s (%s)\n", sample.Router, sample.Host)
+	fmt.Fprintf(tw, "Software version:\t%s\n", s.SoftwareVersion)
+	fmt.Fprintf(tw, "Channel type:\t%v\n", s.ChannelType)
+	fmt.Fprintf(tw, "Modem uptime:\t%v\n", s.ModemUptime)
+	fmt.Fprintf(tw, "Rate (up/down):\t%d/%d kbps\n", s.TotalRate.Up, s.TotalRate.Down)
+	fmt.Fprintf(tw, "Retrains:\t%d\n", s.TotalRetrains)
+	fmt.Fprintf(tw, "Link failures:\tpower %d, signal %d, margin %d, train %d\n", s.Failures.Power, s.Failures.Signal, s.Failures.Margin, s.Failures.Train)
+	fmt.Fprintf(tw, "Unavailable:\t%v\n", s.UnavailableSeconds)
+	fmt.Fprintf(tw, "Packets received:\t%d (%d errors)\n", s.Packets.Received.Count, s.Packets.Received.Errors)
+	fmt.Fprintf(tw, "Packets transmitted:\t%d (%d errors)\n", s.Packets.Transmitted.Count, s.Packets.Transmitted.Errors)
+	fmt.Fprintf(tw, "CRC (near/far):\t%d/%d\n", e.CRC.Near.Interleaved+e.CRC.Near.Fast, e.CRC.Far.Interleaved+e.CRC.Far.Fast)
+	fmt.Fprintf(tw, "FEC (near/far):\t%d/%d\n", e.FEC.Near.Interleaved+e.FEC.Near.Fast, e.FEC.Far.Interleaved+e.FEC.Far.Fast)
+	fmt.Fprintf(tw, "HEC (near/far):\t%d/%d\n", e.HEC.Near, e.HEC.Far)
+	fmt.Fprintf(tw, "Errored seconds (near/far):\t%d/%d\n", e.ErroredSeconds.Near, e.ErroredSeconds.Far)
+	fmt.Fprintf(tw, "Severely errored seconds (near/far):\t%d/%d\n", e.SeverelyErroredSeconds.Near, e.SeverelyErroredSeconds.Far)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Line\tState\tRate up\tRate down\tSNR up\tSNR down\tAtten up\tAtten down\tRetrains\tUptime\t")
+	for i, line := range sample.Lines {
+		fmt.Fprintf(tw, "%d\t%v\t%d\t%d\t%d\t%d\t%.1f\t%.1f\t%d\t%v\t\n",
+			i, line.State,
+			line.Rates.Up, line.Rates.Down,
+			line.SignalNoiseMargin.Up, line.SignalNoiseMargin.Down,
+			line.Attenuation.Up, line.Attenuation.Down,
+			line.Retrains, line.Uptime)
+	}
+
+	return tw.Flush()
+}
+
+// The fields history shows if it isn't asked for any in particular.
+var defaultHistoryFields = []string{
+	"LinkUp",
+	"RateUp",
+	"RateDown",
+	"SignalNoiseMarginUp",
+	"SignalNoiseMarginDown",
+	"AttenuationUp",
+	"AttenuationDown",
+}
+
+// Prints a table of the stored history for each router (or just one), with a
+// row per point per line. Rollups show each field as min/mean/max.
+func historyCommand(args []string, cfg *config.Config, w io.Writer) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: history [flags] [field...]\n\nFields default to %s.\n\nFlags:\n", strings.Join(defaultHistoryFields, " "))
+		fs.PrintDefaults()
+	}
+	router := fs.String("router", "", "only show this router")
+	line := fs.Int("line", -1, "only show this line")
+	from := fs.String("from", "-24h", "start of the range: a time (2006-01-02, 2006-01-02 15:04, or RFC 3339) or a duration before now (-24h)")
+	to := fs.String("to", "0s", "end of the range, in the same format as -from")
+	resolution := fs.String("resolution", "auto", "raw, hourly or daily; auto picks one that suits the range")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	fields := fs.Args()
+	if len(fields) == 0 {
+		fields = defaultHistoryFields
+	}
+
+	if cfg.History.Dir == "" {
+		log.Print("A history directory must be configured, either with -history-dir or in a configuration file.")
+		return exitUsage
+	}
+
+	now := time.Now()
+	start, err := parseTime(*from, now)
+	if err != nil {
+		log.Printf("Invalid -from: %v", err)
+		return exitUsage
+	}
+	end, err := parseTime(*to, now)
+	if err != nil {
+		log.Printf("Invalid -to: %v", err)
+		return exitUsage
+	}
+
+	store, err := openHistory(cfg.History)
+	if err != nil {
+		log.Printf("Error opening history: %v", err)
+		return exitError
+	}
+
+	res := store.Resolution(start, end)
+	if *resolution != "auto" {
+		if res, err = history.ParseResolution(*resolution); err != nil {
+			log.Print(err)
+			return exitUsage
+		}
+	}
+
+	routers := []string{*router}
+	if *router == "" {
+		if routers, err = store.Routers(); err != nil {
+			log.Printf("Error reading history: %v", err)
+			return exitError
+		}
+	}
+
+	for _, name := range routers {
+		points, err := store.QueryResolution(name, res, start, end)
+		if err != nil {
+			log.Printf("[%s] Error reading history: %v", name, err)
+			return exitError
+		}
+
+		if len(routers) > 1 {
+			fmt.Fprintf(w, "==> %s <==\n", name)
+		}
+		if len(points) == 0 {
+			fmt.Fprintf(w, "No %s history between %s and %s.\n", res, start.Format(historyTimeLayout), end.Format(historyTimeLayout))
+			continue
+		}
+
+		if err := writeHistory(w, points, *line, fields); err != nil {
+			log.Printf("[%s] %v", name, err)
+			return exitError
+		}
+	}
+
+	return exitOK
+}
+
+const historyTimeLayout = "2006-01-02 15:04"
+
+func writeHistory(w io.Writer, points []history.Point, line int, fields []string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Time\tLine\t%s\t\n", strings.Join(fields, "\t"))
+
+	for _, p := range points {
+		for i := range p.Lines {
+			if line >= 0 && i != line {
+				continue
+			}
+
+			cells := make([]string, len(fields))
+			for j, field := range fields {
+				cells[j] = historyCell(p, i, field)
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t\n", p.Time.Local().Format(historyTimeLayout), i, strings.Join(cells, "\t"))
+		}
+	}
+
+	return tw.Flush()
+}
+
+// A field's value for a line, or for the modem as a whole if it isn't a line
+// field.
+func historyCell(p history.Point, line int, field string) string {
+	lookup := func(set history.Set) (float64, bool) {
+		if line < len(set.Lines) {
+			if v, ok := set.Lines[line][field]; ok {
+				return v, true
+			}
+		}
+		v, ok := set.Status[field]
+		return v, ok
+	}
+
+	mean, ok := lookup(p.Set)
+	if !ok {
+		return "-"
+	}
+	if p.Rollup == nil {
+		return formatNumber(mean)
+	}
+
+	min, _ := lookup(p.Rollup.Min)
+	max, _ := lookup(p.Rollup.Max)
+	return formatNumber(min) + "/" + formatNumber(mean) + "/" + formatNumber(max)
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// Times can be given absolutely, in local time unless they say otherwise, or
+// as a duration relative to now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q isn't a time or a duration", s)
+}
+
+// Make sure payloadWriter keeps up with the interface.
+var _ actiontec.Recorder = (*payloadWriter)(nil)
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"actiontec"
+	"bytes"
+	"config"
+	"fakerouter"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"sink"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+	frame, err := fakerouter.LoadFrame("src/actiontec/testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(fakerouter.New("admin", "password", []string{frame[0], frame[0]}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	// Nothing should be listening on this once it's closed.
+	closed := httptest.NewServer(nil)
+	closed.Close()
+
+	cases := []struct {
+		command  string
+		host     string
+		password string
+		code     int
+		output   string
+	}{
+		{"status", address, "password", exitOK, "T2200H-31.128L.03"},
+		{"dump", address, "password", exitOK, frame[0]},
+		{"check-login", address, "password", exitOK, "Logged in"},
+		{"check-login", address, "wrong", exitBadCredentials, ""},
+		{"status", address, "wrong", exitBadCredentials, ""},
+		{"status", strings.TrimPrefix(closed.URL, "http://"), "password", exitError, ""},
+		{"reboot", address, "password", exitUsage, ""},
+	}
+
+	for _, c := range cases {
+		cfg := &config.Config{Routers: []config.Router{{
+			Host:     c.host,
+			Password: c.password,
+			Timeout:  config.Duration(5 * time.Second),
+		}}}
+		cfg.SetDefaults()
+
+		output := new(bytes.Buffer)
+		if code := runCommand(c.command, nil, cfg, output); code != c.code {
+			t.Errorf("%s with password %q: expected exit code %d; got %d", c.command, c.password, c.code, code)
+		}
+		if !strings.Contains(output.String(), c.output) {
+			t.Errorf("%s: expected output to contain %q; got %q", c.command, c.output, output.String())
+		}
+	}
+}
+
+func TestHistoryCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{History: config.History{Dir: dir}}
+	cfg.SetDefaults()
+
+	store, err := openHistory(cfg.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := 3; i > 0; i-- {
+		store.Send(&sink.Sample{
+			Source: sink.Source{Router: "home"},
+			Time:   now.Add(time.Duration(-i) * time.Minute),
+			Status: &actiontec.Status{Failures: actiontec.LinkFailures{Power: 4}},
+			Lines: []actiontec.LineStats{
+				{State: actiontec.Up, SignalNoiseMargin: actiontec.UintPair{Up: 7, Down: uint64(i)}},
+				{State: actiontec.Down},
+			},
+		})
+	}
+
+	successCases := []struct {
+		args     []string
+		lines    int
+		expected string
+	}{
+		{nil, 7, "SignalNoiseMarginDown"},
+		{[]string{"-line", "0", "-from", "-2m30s", "SignalNoiseMarginDown", "FailuresPower"}, 3, " 0 1 4"},
+		{[]string{"-router", "work"}, 1, "No raw history"},
+	}
+
+	for _, c := range successCases {
+		output := new(bytes.Buffer)
+		if code := runCommand("history", c.args, cfg, output); code != exitOK {
+			t.Errorf("%v: expected exit code %d; got %d", c.args, exitOK, code)
+		}
+
+		// Ignore the alignment.
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		if len(lines) != c.lines || !strings.Contains(strings.Join(strings.Fields(output.String()), " "), c.expected) {
+			t.Errorf("%v: expected %d lines containing %q; got %q", c.args, c.lines, c.expected, output.String())
+		}
+	}
+
+	errorCases := [][]string{
+		{"-from", "last tuesday"},
+		{"-resolution", "weekly"},
+		{"-nope"},
+	}
+
+	for _, args := range errorCases {
+		if code := runCommand("history", args, cfg, ioutil.Discard); code != exitUsage {
+			t.Errorf("%v: expected exit code %d; got %d", args, exitUsage, code)
+		}
+	}
+
+	if code := runCommand("history", nil, &config.Config{}, ioutil.Discard); code != exitUsage {
+		t.Errorf("Expected exit code %d without a history directory; got %d", exitUsage, code)
+	}
+	if code := runCommand("status", []string{"now"}, cfg, ioutil.Discard); code != exitUsage {
+		t.Errorf("Expected exit code %d for status with arguments; got %d", exitUsage, code)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2017, 7, 14, 12, 0, 0, 0, time.Local)
+
+	successCases := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"-24h", now.Add(-24 * time.Hour)},
+		{"0s", now},
+		{"2017-07-11", time.Date(2017, 7, 11, 0, 0, 0, 0, time.Local)},
+		{"2017-07-11 22:30", time.Date(2017, 7, 11, 22, 30, 0, 0, time.Local)},
+		{"2017-07-11T22:30", time.Date(2017, 7, 11, 22, 30, 0, 0, time.Local)},
+		{"2017-07-11T22:30:00Z", time.Date(2017, 7, 11, 22, 30, 0, 0, time.UTC)},
+	}
+
+	for _, c := range successCases {
+		got, err := parseTime(c.input, now)
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected")
+		} else if !got.Equal(c.expected) {
+			t.Errorf("%s: expected %v; got %v", c.input, c.expected, got)
+		}
+	}
+
+	errorCases := []string{"", "yesterday", "2017-13-01"}
+	for _, input := range errorCases {
+		if _, err := parseTime(input, now); err == nil {
+			t.Errorf("%q: expected an error; got none", input)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"actiontec"
+	"capture"
+	"config"
+	"context"
+	"errors"
+	"fmt"
+	"health"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sink"
+	"sync"
+	"time"
+)
+
+// Everything that runs when we're collecting continuously: the collectors,
+// the sinks they feed, and a collection loop per router. Reloading the
+// configuration builds another of these, and only stops the old one once the
+// new one has been built.
+type daemon struct {
+	cfg        *config.Config
+	collectors []*collector
+	sinks      sink.Multi
+	files      []*os.File
+	monitor    *health.Monitor
+	pullOnly   bool
+
+	// Every server the daemon runs, including those for the sinks, which
+	// aren't listening until start is called. The health server's here too,
+	// if the health endpoints are enabled, since it has to be shut down
+	// separately.
+	servers []*http.Server
+	server  *http.Server
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Receives an error if a collection loop gives up, which means the whole
+	// process should exit.
+	failed chan error
+}
+
+// Checks the things that only matter when running continuously, which
+// Validate leaves alone.
+func checkDaemonConfig(cfg *config.Config) error {
+	if len(cfg.Routers) == 0 {
+		return errors.New("At least one router must be configured, either with -host or in a configuration file.")
+	}
+	if len(cfg.Outputs) == 0 && cfg.History.Dir == "" && len(cfg.Alerts.Rules) == 0 {
+		return errors.New("At least one output, a history directory, or an alert rule must be configured, either with flags or in a configuration file.")
+	}
+
+	return nil
+}
+
+// Builds and starts a daemon. The monitor outlives the daemon, so that the
+// collector's health isn't reset by reloading the configuration.
+func startDaemon(cfg *config.Config, monitor *health.Monitor) (*daemon, error) {
+	d, err := newDaemon(cfg, monitor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.start(); err != nil {
+		d.stop()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Creates the collectors and opens everything the sinks need, without starting
+// anything: nothing's listening, and nothing's being collected. If any of it
+// fails, whatever was opened is closed again.
+func newDaemon(cfg *config.Config, monitor *health.Monitor) (*daemon, error) {
+	d := &daemon{
+		cfg:     cfg,
+		monitor: monitor,
+		failed:  make(chan error, len(cfg.Routers)),
+	}
+	fail := func(err error) (*daemon, error) {
+		for _, f := range d.files {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	// Create our collectors for interacting with the routers.
+	for _, router := range cfg.Routers {
+		coll, err := newCollector(router)
+		if err != nil {
+			return fail(fmt.Errorf("Error creating context: %w", err))
+		}
+
+		if router.Record != "" {
+			f, err := os.OpenFile(router.Record, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return fail(fmt.Errorf("Error opening capture file: %w", err))
+			}
+
+			w := capture.NewWriter(f, coll.source())
+			w.SetProfile(actiontec.LookupProfile(router.Profile))
+			coll.ctx.SetRecorder(w)
+			d.files = append(d.files, f)
+		}
+
+		coll.monitor = monitor
+		d.collectors = append(d.collectors, coll)
+	}
+
+	store, err := openHistory(cfg.History)
+	if err != nil {
+		return fail(fmt.Errorf("Error opening history: %w", err))
+	}
+
+	d.sinks, d.servers, d.pullOnly, err = createSinks(cfg.Outputs, cfg.Alerts, d.collectors, monitor, store)
+	if err != nil {
+		return fail(err)
+	}
+
+	if cfg.Health.Listen != "" {
+		d.server = &http.Server{Addr: cfg.Health.Listen, Handler: health.NewHandler(monitor, cfg.Health.ReadyIntervals)}
+		d.servers = append(d.servers, d.server)
+	}
+
+	return d, nil
+}
+
+// Starts serving, and starts the collection loops. If a server can't listen,
+// the error's returned, and the caller should stop the daemon.
+func (d *daemon) start() error {
+	intervals := make(map[string]time.Duration)
+	for _, router := range d.cfg.Routers {
+		intervals[router.Name] = time.Duration(router.Interval)
+	}
+	d.monitor.SetRouters(intervals)
+
+	for _, server := range d.servers {
+		if err := listen(server); err != nil {
+			return err
+		}
+	}
+	if d.server != nil {
+		log.Printf("Serving health endpoints on %s", d.server.Addr)
+	}
+
+	d.resume()
+	return nil
+}
+
+// Starts the collection loops, and health reporting. If Prometheus is the only
+// thing configured, there's no need for any of that: it'll scrape when it's
+// asked to.
+func (d *daemon) resume() {
+	var ctx context.Context
+	ctx, d.cancel = context.WithCancel(context.Background())
+
+	if d.pullOnly {
+		return
+	}
+
+	policy, _ := parsePermanentPolicy(d.cfg.Retry.PermanentErrors)
+
+	for _, coll := range d.collectors {
+		d.wg.Add(1)
+		go func(coll *collector) {
+			defer d.wg.Done()
+			if err := run(ctx, coll, d.sinks, d.cfg.Retry, policy); err != nil {
+				d.failed <- err
+			}
+		}(coll)
+	}
+
+	// Health is reported as often as the most frequently collected router.
+	interval := time.Duration(d.cfg.Routers[0].Interval)
+	for _, router := range d.cfg.Routers {
+		if time.Duration(router.Interval) < interval {
+			interval = time.Duration(router.Interval)
+		}
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.reportHealth(ctx, interval)
+	}()
+}
+
+// Cancels any collections in flight and waits for the loops to finish, so
+// that nothing more is sent to the sinks until resume is called. Everything
+// else carries on.
+func (d *daemon) pause() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+}
+
+// Replaces the daemon with one for a new configuration. The collection loops
+// are paused while the new one is built, so that the two don't both write to
+// the same files; if building it fails, they're resumed, and the old daemon
+// carries on as though nothing happened. Otherwise the old one is stopped,
+// and the new one started. If that fails (say, because something else has
+// grabbed the address it's meant to listen on), the old configuration is
+// started again. Either way, any error is returned along with the daemon
+// that's now running, which is nil if there isn't one.
+func (d *daemon) reload(cfg *config.Config) (*daemon, error) {
+	d.pause()
+
+	next, err := newDaemon(cfg, d.monitor)
+	if err != nil {
+		d.resume()
+		return d, err
+	}
+
+	if err := d.stop(); err != nil {
+		log.Printf("Error flushing sinks: %v", err)
+	}
+
+	if err = next.start(); err == nil {
+		return next, nil
+	}
+	next.stop()
+
+	old, oerr := startDaemon(d.cfg, d.monitor)
+	if oerr != nil {
+		return nil, fmt.Errorf("%v (and restarting with the old configuration failed: %v)", err, oerr)
+	}
+	return old, err
+}
+
+// Sends a CollectorHealth event for each router and a SinkHealth event for
+// each sink every interval, until ctx is cancelled.
+func (d *daemon) reportHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			snapshot := d.monitor.Snapshot()
+
+			var events []*sink.Event
+			for _, coll := range d.collectors {
+				stats := snapshot.Routers[coll.router.Name]
+				events = append(events, health.RouterEvent(coll.source(), &stats, now))
+			}
+			for _, name := range snapshot.SinkNames() {
+				stats := snapshot.Sinks[name]
+				events = append(events, health.SinkEvent(name, &stats, now))
+			}
+
+			for _, event := range events {
+				if err := d.sinks.SendEvent(event); err != nil {
+					log.Printf("Error sending health to sinks: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Cancels any collections in flight and waits for the loops to finish, stops
+// serving the health endpoints, logs out of every router, then flushes and
+// closes the sinks. The error is from the sinks, since that's where data can
+// be lost.
+func (d *daemon) stop() error {
+	d.pause()
+
+	if d.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		d.server.Shutdown(ctx)
+		cancel()
+	}
+
+	for _, coll := range d.collectors {
+		if err := coll.Logout(); err != nil {
+			log.Printf("[%s] Error logging out: %v", coll.router.Name, err)
+		}
+	}
+
+	for _, f := range d.files {
+		f.Close()
+	}
+
+	return d.sinks.Close()
+}
+
+// Listens on a server's address, and serves in the background. Listening
+// happens before returning, so that an address that's in use is an error the
+// caller can do something about.
+func listen(server *http.Server) error {
+	l, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			log.Printf("Error serving on %s: %v", server.Addr, err)
+		}
+	}()
+
+	return nil
+}
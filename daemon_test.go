@@ -0,0 +1,174 @@
+package main
+
+import (
+	"config"
+	"fakerouter"
+	"health"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemonStop(t *testing.T) {
+	frame, err := fakerouter.LoadFrame("src/actiontec/testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := fakerouter.New("admin", "password", []string{frame[0], frame[0]})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{
+		Routers: []config.Router{{
+			Host:     strings.TrimPrefix(server.URL, "http://"),
+			Password: "password",
+		}},
+		Outputs: []config.Output{{Type: "influx", File: filepath.Join(dir, "out.influx")}},
+	}
+	cfg.SetDefaults()
+
+	d, err := startDaemon(cfg, health.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend we were interrupted part way through a collection.
+	if err := d.collectors[0].ctx.Login("admin", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if !router.LoggedIn("127.0.0.1") {
+		t.Fatal("Expected to be logged in")
+	}
+
+	// The collection loop is sleeping until the first interval, which is much
+	// longer than this; stopping shouldn't wait for it.
+	done := make(chan error)
+	go func() {
+		done <- d.stop()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the daemon to stop")
+	}
+
+	if router.LoggedIn("127.0.0.1") {
+		t.Errorf("Expected to be logged out")
+	}
+}
+
+func TestDaemonReload(t *testing.T) {
+	frame, err := fakerouter.LoadFrame("src/actiontec/testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := fakerouter.New("admin", "password", []string{frame[0], frame[0]})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out.influx")
+	config := func(file, listen string) *config.Config {
+		cfg := &config.Config{
+			Routers: []config.Router{{
+				Host:     strings.TrimPrefix(server.URL, "http://"),
+				Password: "password",
+			}},
+			Outputs: []config.Output{{Type: "influx", File: file}},
+			Health:  config.Health{Listen: listen},
+		}
+		cfg.SetDefaults()
+		cfg.Routers[0].Interval = config.Duration(20 * time.Millisecond)
+
+		return cfg
+	}
+
+	// Waits for the output file to grow, which means a daemon's collecting.
+	collecting := func() bool {
+		before, _ := os.Stat(out)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if after, err := os.Stat(out); err == nil && (before == nil || after.Size() > before.Size()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	d, err := startDaemon(config(out, ""), health.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !collecting() {
+		t.Fatal("Expected the daemon to be collecting")
+	}
+
+	// A configuration that can't be built leaves the old daemon running.
+	next, err := d.reload(config(filepath.Join(dir, "missing", "out.influx"), ""))
+	if err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+	if next != d {
+		t.Errorf("Expected the old daemon to carry on")
+	}
+	if !collecting() {
+		t.Errorf("Expected the old daemon to still be collecting")
+	}
+
+	// One that can be built but can't listen goes back to the old configuration.
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	next, err = d.reload(config(out, taken.Addr().String()))
+	if err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+	if next == nil || next == d || next.cfg.Health.Listen != "" {
+		t.Fatalf("Expected to be running the old configuration again: %+v", next)
+	}
+	d = next
+	if !collecting() {
+		t.Errorf("Expected the restarted daemon to be collecting")
+	}
+
+	// And one that's fine replaces it.
+	next, err = d.reload(config(out, "127.0.0.1:0"))
+	if err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+	if next == nil || next == d {
+		t.Fatalf("Expected a new daemon")
+	}
+	d = next
+	if !collecting() {
+		t.Errorf("Expected the new daemon to be collecting")
+	}
+
+	if err := d.stop(); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+}
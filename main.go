@@ -2,166 +2,433 @@ package main
 
 import (
 	"actiontec"
-	"bytes"
-	"encoding/json"
+	"config"
+	"context"
 	"flag"
-	"insights"
+	"fmt"
+	"health"
+	"history"
 	"log"
+	"os"
+	"os/signal"
+	"sink"
+	"strings"
+	"syscall"
 	"time"
 )
 
-// These functions are a little Insights-specific, although possibly still
-// useful outside that context if you need JSON.
-func createEvents(status *actiontec.Status, lines []actiontec.LineStats) ([]byte, error) {
-	buffer := bytes.NewBufferString("[")
-
-	for i, line := range lines {
-		data, err := lineStatsToJSON(i, &line)
-		if err != nil {
-			return nil, err
-		}
-
-		buffer.Write(data)
-		buffer.WriteRune(',')
-	}
-
-	data, err := statusToJSON(status)
-	if err != nil {
-		return nil, err
-	}
-
-	buffer.Write(data)
-	buffer.WriteRune(']')
-
-	return buffer.Bytes(), nil
-}
-
-func lineStatsToJSON(line int, stats *actiontec.LineStats) ([]byte, error) {
-	return json.Marshal(struct {
-		EventType             string `json:"eventType"`
-		Line                  int
-		RateUp                uint64
-		RateDown              uint64
-		SignalNoiseMarginUp   uint64
-		SignalNoiseMarginDown uint64
-		AttenuationUp         float64
-		AttenuationDown       float64
-		Retrains              uint64
-	}{
-		"LineStats",
-		line,
-		stats.Rates.Up,
-		stats.Rates.Down,
-		stats.SignalNoiseMargin.Up,
-		stats.SignalNoiseMargin.Down,
-		stats.Attenuation.Up,
-		stats.Attenuation.Down,
-		stats.Retrains,
-	},
-	)
-}
-
-func statusToJSON(status *actiontec.Status) ([]byte, error) {
-	return json.Marshal(struct {
-		EventType string `json:"eventType"`
-		RateUp    uint64
-		RateDown  uint64
-		Retrains  uint64
-	}{
-		"ModemStats",
-		status.TotalRate.Up,
-		status.TotalRate.Down,
-		status.TotalRetrains,
-	},
-	)
-}
-
-// Command line flags.
+// Command line flags. Everything but -config and -replay can also be set in a
+// configuration file; flags describing an output add one to whatever the
+// file describes, flags describing a router override the matching router in
+// the file (see applyRouterFlags), and the rest (retry, health and history)
+// override the file.
 var account int
 var apiKey string
+var configFile string
+var dashboardListen string
+var dialTimeout time.Duration
+var healthListen string
+var historyDir string
 var host string
+var influxFile string
+var influxURL string
+var insightsRegion string
+var insightsSpool string
 var interval int
 var password string
+var permanentErrors string
+var profile string
+var prometheusCache time.Duration
+var prometheusListen string
+var recordFile string
+var replayFile string
+var responseTimeout time.Duration
+var retryMax time.Duration
+var retryMin time.Duration
+var timeout time.Duration
 var username string
 
 func init() {
-	flag.IntVar(&account, "account", 0, "New Relic Insights account number")
-	flag.StringVar(&apiKey, "apikey", "", "New Relic Insights API key")
+	// Secrets can come from the environment, so they don't have to show up in
+	// ps.
+	flag.IntVar(&account, "account", 0, "New Relic Insights account number (enables the Insights sink)")
+	flag.StringVar(&apiKey, "apikey", os.Getenv("INSIGHTS_API_KEY"), "New Relic Insights API key (default $INSIGHTS_API_KEY)")
+	flag.StringVar(&configFile, "config", "", "configuration file")
+	flag.StringVar(&dashboardListen, "dashboard-listen", "", "address to serve the web dashboard on (eg :8081)")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 0, "how long to wait to connect to the router (default 10s)")
+	flag.StringVar(&healthListen, "health-listen", "", "address to serve /healthz, /readyz and /status on (eg :8080)")
+	flag.StringVar(&historyDir, "history-dir", "", "directory to keep the history of every sample in (enables the history store)")
 	flag.StringVar(&host, "host", "", "router IP address or host name")
+	flag.StringVar(&influxFile, "influx-file", "", "file to append InfluxDB line protocol to, or - for stdout (enables the InfluxDB sink)")
+	flag.StringVar(&influxURL, "influx-url", "", "InfluxDB write URL, eg http://localhost:8086/write?db=modem (enables the InfluxDB sink)")
+	flag.StringVar(&insightsRegion, "insights-region", "us", "New Relic Insights region: us or eu")
+	flag.StringVar(&insightsSpool, "insights-spool", "", "directory to keep Insights events that couldn't be sent in, until they can be")
 	flag.IntVar(&interval, "interval", 60, "interval between stat gathering (in seconds)")
-	flag.StringVar(&password, "password", "", "router admin password")
+	flag.StringVar(&password, "password", os.Getenv("ACTIONTEC_PASSWORD"), "router admin password (default $ACTIONTEC_PASSWORD)")
+	flag.StringVar(&permanentErrors, "permanent-errors", "exit", "what to do on errors that retrying won't fix, such as bad credentials: exit or retry")
+	flag.StringVar(&profile, "profile", "", "parser profile to use if the firmware isn't detected properly: "+strings.Join(actiontec.ProfileNames(), ", "))
+	flag.DurationVar(&prometheusCache, "prometheus-cache", 30*time.Second, "how long to cache router data between Prometheus scrapes")
+	flag.StringVar(&prometheusListen, "prometheus-listen", "", "address to serve Prometheus metrics on (eg :9101; enables the Prometheus exporter)")
+	flag.StringVar(&recordFile, "record", "", "file to append raw router responses to, for later replay")
+	flag.StringVar(&replayFile, "replay", "", "capture file to send through the sinks instead of talking to a router")
+	flag.DurationVar(&responseTimeout, "response-timeout", 0, "how long to wait for each response from the router (default 30s)")
+	flag.DurationVar(&retryMax, "retry-max", 5*time.Minute, "longest delay between retries when the router can't be reached")
+	flag.DurationVar(&retryMin, "retry-min", 5*time.Second, "first delay between retries when the router can't be reached")
+	flag.DurationVar(&timeout, "timeout", 0, "how long a complete collection from the router may take (default 2m)")
 	flag.StringVar(&username, "username", "admin", "router admin user name")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), commandUsage)
+	}
 }
 
 func main() {
 	// Parse and check flags.
 	flag.Parse()
 
-	if account == 0 {
-		log.Fatal("Insights account number must be provided.")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Invalid configuration:\n%v", err)
+		os.Exit(exitUsage)
 	}
 
-	if apiKey == "" {
-		log.Fatal("Insights API key must be provided.")
+	// One shot commands don't need any outputs.
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Arg(0), flag.Args()[1:], cfg, os.Stdout))
 	}
 
-	if host == "" {
-		log.Fatal("Router host name or IP address must be provided.")
+	// Replaying doesn't need a router at all, so handle that first.
+	if replayFile != "" {
+		if len(cfg.Outputs) == 0 && cfg.History.Dir == "" {
+			log.Fatal("At least one output or a history directory must be configured, either with flags or in a configuration file.")
+		}
+
+		store, err := openHistory(cfg.History)
+		if err != nil {
+			log.Fatalf("Error opening history: %v", err)
+		}
+
+		sinks, _, _, err := createSinks(cfg.Outputs, cfg.Alerts, nil, nil, store)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = replay(replayFile, sinks)
+		if cerr := sinks.Close(); cerr != nil {
+			log.Printf("Error closing sinks: %v", cerr)
+		}
+		if err != nil {
+			log.Fatalf("Error replaying capture: %v", err)
+		}
+		return
 	}
 
-	if interval < 30 {
-		log.Fatal("Interval must be greater than or equal to 30.")
+	if err := checkDaemonConfig(cfg); err != nil {
+		log.Printf("Invalid configuration: %v", err)
+		os.Exit(exitUsage)
 	}
 
-	if password == "" {
-		log.Fatal("Password must be provided.")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Gather and send data from each router every interval, until we're told to
+	// stop. SIGHUP reloads the configuration; if the new one is invalid, we
+	// carry on with the old one.
+	monitor := health.New()
+	d, err := startDaemon(cfg, monitor)
+	if err != nil {
+		log.Fatal(err)
 	}
+	code := exitOK
+	for stopping := false; !stopping; {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Printf("Received %v; shutting down...", sig)
+				stopping = true
+				break
+			}
 
-	if username == "" {
-		log.Fatal("User name must be provided.")
+			cfg, err := loadConfig()
+			if err == nil {
+				err = checkDaemonConfig(cfg)
+			}
+			if err != nil {
+				log.Printf("Not reloading; invalid configuration:\n%v", err)
+				break
+			}
+
+			log.Print("Reloading configuration...")
+			next, err := d.reload(cfg)
+			if err != nil {
+				log.Printf("Not reloading: %v", err)
+			}
+			if next == nil {
+				log.Printf("Nothing left running; shutting down.")
+				os.Exit(exitError)
+			}
+			d = next
+
+		case err := <-d.failed:
+			log.Printf("%v; shutting down...", err)
+			code = exitError
+			stopping = true
+		}
 	}
 
-	// Create our context for interacting with the router. Originally, a context
-	// was created on each tick, but Go seemed to be unable to GC the open file
-	// descriptors for the HTTP client, which is unfortunate.
-	ctx, err := actiontec.NewContext(host)
-	if err != nil {
-		log.Fatalf("Error creating context: %v", err)
+	// Logging out of a wedged router can take a while; a second signal means
+	// whoever sent it really wants us gone.
+	go func() {
+		sig := <-signals
+		log.Fatalf("Received %v again; exiting without finishing shutdown.", sig)
+	}()
+
+	if err := d.stop(); err != nil {
+		log.Printf("Error flushing sinks: %v", err)
+		code = exitError
 	}
 
-	// Set up a ticker and gather and send data each tick. I haven't bothered
-	// going this in a goroutine with fancy signal handling: you want to kill it,
-	// just kill it via Ctrl-C or kill.
-	ticker := time.NewTicker(time.Second * time.Duration(interval))
-	for _ = range ticker.C {
-		log.Print("Gathering data...")
+	log.Printf("Shut down (exit status %d).", code)
+	os.Exit(code)
+}
 
-		// We'll re-login every time: it doesn't hurt, and the Actiontec UI seems
-		// to base the logout timeout on when you logged in, not your last
-		// activity.
-		if err := ctx.Login(username, password); err != nil {
-			log.Fatalf("Error logging into router: %v", err)
+// Load the configuration file, if there is one, and apply the flags on top.
+func loadConfig() (*config.Config, error) {
+	cfg := new(config.Config)
+	if configFile != "" {
+		var err error
+		if cfg, err = config.Load(configFile); err != nil {
+			return nil, err
 		}
+	}
 
-		status, stats, err := ctx.GetStatus()
-		if err != nil {
-			log.Fatalf("Error getting stats from router: %v", err)
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if err := applyRouterFlags(cfg, set); err != nil {
+		return nil, err
+	}
+
+	if account != 0 {
+		cfg.Outputs = append(cfg.Outputs, config.Output{Type: "insights", Account: account, APIKey: apiKey, Region: insightsRegion, Spool: insightsSpool})
+	}
+
+	if influxURL != "" {
+		cfg.Outputs = append(cfg.Outputs, config.Output{Type: "influx", URL: influxURL})
+	}
+
+	if influxFile != "" {
+		cfg.Outputs = append(cfg.Outputs, config.Output{Type: "influx", File: influxFile})
+	}
+
+	if prometheusListen != "" {
+		cfg.Outputs = append(cfg.Outputs, config.Output{Type: "prometheus", Listen: prometheusListen, Cache: config.Duration(prometheusCache)})
+	}
+
+	if dashboardListen != "" {
+		cfg.Outputs = append(cfg.Outputs, config.Output{Type: "dashboard", Listen: dashboardListen})
+	}
+
+	if healthListen != "" {
+		cfg.Health.Listen = healthListen
+	}
+
+	if historyDir != "" {
+		cfg.History.Dir = historyDir
+	}
+
+	if set["retry-min"] {
+		cfg.Retry.Min = config.Duration(retryMin)
+	}
+	if set["retry-max"] {
+		cfg.Retry.Max = config.Duration(retryMax)
+	}
+	if set["permanent-errors"] {
+		cfg.Retry.PermanentErrors = permanentErrors
+	}
+
+	cfg.SetDefaults()
+
+	errs, _ := cfg.Validate().(config.ValidationError)
+	errs = append(errs, checkAlertFields(cfg)...)
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+// The flags that describe a router, other than -host.
+var routerFlags = []string{"username", "password", "interval", "timeout", "dial-timeout", "response-timeout", "record", "profile"}
+
+// Applies the router flags. -host picks the router in the file with that host
+// or name, and the other router flags override its settings; if there isn't
+// one, the flags describe a new router. Without -host, the other router flags
+// can only apply to the file's router if it has exactly one.
+func applyRouterFlags(cfg *config.Config, set map[string]bool) error {
+	var r *config.Router
+
+	if host != "" {
+		for i := range cfg.Routers {
+			if cfg.Routers[i].Host == host || cfg.Routers[i].Name == host {
+				r = &cfg.Routers[i]
+				break
+			}
+		}
+
+		if r == nil {
+			cfg.Routers = append(cfg.Routers, config.Router{
+				Host:            host,
+				Username:        username,
+				Password:        password,
+				Interval:        config.Duration(time.Duration(interval) * time.Second),
+				Timeout:         config.Duration(timeout),
+				DialTimeout:     config.Duration(dialTimeout),
+				ResponseTimeout: config.Duration(responseTimeout),
+				Record:          recordFile,
+				Profile:         profile,
+			})
+			return nil
+		}
+	} else {
+		for _, name := range routerFlags {
+			if !set[name] {
+				continue
+			}
+			if len(cfg.Routers) != 1 {
+				return fmt.Errorf("-%s needs -host to say which router it applies to", name)
+			}
+
+			r = &cfg.Routers[0]
+		}
+
+		if r == nil {
+			return nil
+		}
+	}
+
+	if set["username"] {
+		r.Username = username
+	}
+	if set["password"] {
+		r.Password = password
+	}
+	if set["interval"] {
+		r.Interval = config.Duration(time.Duration(interval) * time.Second)
+	}
+	if set["timeout"] {
+		r.Timeout = config.Duration(timeout)
+	}
+	if set["dial-timeout"] {
+		r.DialTimeout = config.Duration(dialTimeout)
+	}
+	if set["response-timeout"] {
+		r.ResponseTimeout = config.Duration(responseTimeout)
+	}
+	if set["record"] {
+		r.Record = recordFile
+	}
+	if set["profile"] {
+		r.Profile = profile
+	}
+
+	return nil
+}
+
+// Alert rules are checked against the fields the history has, which the
+// config package doesn't know about.
+func checkAlertFields(cfg *config.Config) config.ValidationError {
+	var errs config.ValidationError
+	for i, r := range cfg.Alerts.Rules {
+		if r.Field != "" && !history.IsField(r.Field) {
+			errs = append(errs, &config.FieldError{
+				Key:     fmt.Sprintf("alerts.rules[%d].field", i),
+				Message: fmt.Sprintf("unknown field %q", r.Field),
+			})
+		}
+	}
+
+	return errs
+}
+
+// The main collection loop for a single router. When the router can't be
+// reached, a RouterUnreachable event is sent to the sinks and we retry with
+// exponential backoff, going back to the normal interval once it's reachable
+// again. Retrains, line state changes and reboots are sent as events too.
+//
+// Returns nil once ctx is cancelled, or an error if the policy says a
+// permanent error means giving up.
+func run(ctx context.Context, coll *collector, sinks sink.Multi, cfg config.Retry, policy permanentPolicy) error {
+	name := coll.router.Name
+	interval := time.Duration(coll.router.Interval)
+	retry := &backoff{min: time.Duration(cfg.Min), max: time.Duration(cfg.Max)}
+	tracker := newTracker()
+	failures := 0
+	delay := interval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
 		}
+		log.Printf("[%s] Gathering data...", name)
 
-		events, err := createEvents(status, stats)
+		sample, err := coll.Collect(ctx)
+		if ctx.Err() != nil {
+			// Cancelled part way through: whatever happened isn't the router's
+			// fault, and there's nobody left to tell about it.
+			return nil
+		}
 		if err != nil {
-			log.Fatalf("Error buildling JSON: %v", err)
+			permanent := isPermanent(err)
+			if permanent && policy == exitOnPermanent {
+				return fmt.Errorf("[%s] %v", name, err)
+			}
+
+			failures++
+			delay = retry.Next()
+			log.Printf("[%s] %v (attempt %d; retrying in %v)", name, err, failures, delay)
+
+			if err := sinks.SendEvent(&sink.Event{
+				Source: coll.source(),
+				Time:   time.Now(),
+				Type:   "RouterUnreachable",
+				Attributes: map[string]interface{}{
+					"Error":     err.Error(),
+					"Permanent": permanent,
+					"Attempt":   failures,
+				},
+			}); err != nil {
+				log.Printf("[%s] Error sending event to sinks: %v", name, err)
+			}
+			continue
 		}
 
-		log.Print("Sending data to Insights...")
-		if err := insights.Insert(account, apiKey, events); err != nil {
-			log.Printf("Error inserting data to Insights: %v", err)
+		failures = 0
+		retry.Reset()
+		delay = interval
+
+		events := tracker.process(sample)
+
+		log.Printf("[%s] Sending data to sinks...", name)
+		if err := sinks.Send(sample); err != nil {
+			log.Printf("[%s] Error sending data to sinks: %v", name, err)
 		} else {
-			log.Print("Data inserted.")
+			log.Printf("[%s] Data sent.", name)
 		}
 
-		if err := ctx.Logout(); err != nil {
-			log.Printf("Error logging out (will attempt to continue): %v", err)
+		sendEvents(sinks, events)
+	}
+}
+
+func sendEvents(sinks sink.Multi, events []*sink.Event) {
+	for _, event := range events {
+		log.Printf("[%s] %s %v", event.Router, event.Type, event.Attributes)
+		if err := sinks.SendEvent(event); err != nil {
+			log.Printf("[%s] Error sending event to sinks: %v", event.Router, err)
 		}
 	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"config"
+	"testing"
+	"time"
+)
+
+func TestApplyRouterFlags(t *testing.T) {
+	defer func(h, p string, i int) {
+		host, password, interval = h, p, i
+	}(host, password, interval)
+
+	fileRouters := func() []config.Router {
+		return []config.Router{
+			{Name: "home", Host: "192.168.0.1", Password: "file", Interval: config.Duration(time.Minute)},
+			{Name: "office", Host: "10.0.0.1", Password: "file", Interval: config.Duration(time.Minute)},
+		}
+	}
+
+	successCases := []struct {
+		host     string
+		set      map[string]bool
+		routers  []config.Router
+		index    int
+		expected string
+		count    int
+	}{
+		// -host matches a router by host or by name, and overrides it.
+		{"192.168.0.1", map[string]bool{"host": true, "password": true}, fileRouters(), 0, "flag", 2},
+		{"office", map[string]bool{"host": true, "password": true}, fileRouters(), 1, "flag", 2},
+		// Only the flags that were given override the file.
+		{"office", map[string]bool{"host": true}, fileRouters(), 1, "file", 2},
+		// Otherwise it adds a router.
+		{"172.16.0.1", map[string]bool{"host": true, "password": true}, fileRouters(), 2, "flag", 3},
+		{"172.16.0.1", map[string]bool{"host": true}, nil, 0, "flag", 1},
+		// Without -host, a lone router is the only one it could mean.
+		{"", map[string]bool{"password": true}, fileRouters()[:1], 0, "flag", 1},
+		{"", map[string]bool{}, fileRouters(), 0, "file", 2},
+	}
+
+	for _, c := range successCases {
+		host, password, interval = c.host, "flag", 90
+		cfg := &config.Config{Routers: c.routers}
+
+		if err := applyRouterFlags(cfg, c.set); err != nil {
+			t.Errorf("Got an error when one wasn't expected: %v", err)
+			continue
+		}
+
+		if len(cfg.Routers) != c.count {
+			t.Errorf("Invalid router count: got %d; expected %d", len(cfg.Routers), c.count)
+			continue
+		}
+
+		r := cfg.Routers[c.index]
+		if r.Password != c.expected {
+			t.Errorf("Invalid password for %s: got %s; expected %s", c.host, r.Password, c.expected)
+		}
+		if c.count == len(c.routers) && time.Duration(r.Interval) != time.Minute {
+			t.Errorf("Interval was overridden without -interval: got %v", r.Interval)
+		}
+	}
+
+	errorCases := []struct {
+		set     map[string]bool
+		routers []config.Router
+	}{
+		{map[string]bool{"password": true}, nil},
+		{map[string]bool{"password": true}, fileRouters()},
+		{map[string]bool{"interval": true}, fileRouters()},
+	}
+
+	for _, c := range errorCases {
+		host = ""
+		cfg := &config.Config{Routers: c.routers}
+
+		if err := applyRouterFlags(cfg, c.set); err == nil {
+			t.Errorf("Expected an error; got none")
+		}
+	}
+}
+
+func TestCheckAlertFields(t *testing.T) {
+	cfg := &config.Config{
+		Alerts: config.Alerts{
+			Rules: []config.AlertRule{
+				{Name: "snr", Field: "SignalNoiseMarginDown"},
+				{Name: "up", Field: "LinkUp"},
+				{Name: "bogus", Field: "Bogus"},
+				{Name: "empty"},
+			},
+		},
+	}
+
+	errs := checkAlertFields(cfg)
+	if len(errs) != 1 || errs[0].Key != "alerts.rules[2].field" {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"actiontec"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Exponential backoff for when the router can't be reached. Each call to Next
+// doubles the delay, up to max.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func (b *backoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current *= 2
+	}
+
+	if b.current > b.max {
+		b.current = b.max
+	}
+
+	return b.current
+}
+
+func (b *backoff) Reset() {
+	b.current = 0
+}
+
+// What to do when an error that retrying won't fix happens.
+type permanentPolicy int
+
+const (
+	exitOnPermanent permanentPolicy = iota
+	retryOnPermanent
+)
+
+func parsePermanentPolicy(s string) (permanentPolicy, error) {
+	if s == "exit" {
+		return exitOnPermanent, nil
+	} else if s == "retry" {
+		return retryOnPermanent, nil
+	}
+
+	return exitOnPermanent, fmt.Errorf("Unknown permanent error policy: %s", s)
+}
+
+// Errors are transient unless we know otherwise: the router rebooting, the
+// network dropping out, and even the odd garbled response all clear up on
+// their own. Bad credentials don't.
+func isPermanent(err error) bool {
+	return errors.Is(err, actiontec.ErrBadCredentials)
+}
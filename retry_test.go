@@ -0,0 +1,41 @@
+package main
+
+import (
+	"actiontec"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: time.Second, max: 5 * time.Second}
+
+	for _, expected := range []time.Duration{1, 2, 4, 5, 5} {
+		if d := b.Next(); d != expected*time.Second {
+			t.Errorf("Invalid delay: got %v; expected %v", d, expected*time.Second)
+		}
+	}
+
+	b.Reset()
+	if d := b.Next(); d != time.Second {
+		t.Errorf("Invalid delay after reset: got %v; expected %v", d, time.Second)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	cases := []struct {
+		err       error
+		permanent bool
+	}{
+		{actiontec.ErrBadCredentials, true},
+		{fmt.Errorf("Error logging into router: %w", actiontec.ErrBadCredentials), true},
+		{errors.New("connection refused"), false},
+	}
+
+	for _, c := range cases {
+		if p := isPermanent(c.err); p != c.permanent {
+			t.Errorf("Invalid result for %v: got %v; expected %v", c.err, p, c.permanent)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"alert"
+	"capture"
+	"config"
+	"context"
+	"dashboard"
+	"fmt"
+	"health"
+	"history"
+	"influx"
+	"insights"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"prometheus"
+	"sink"
+	"spool"
+	"time"
+)
+
+// Create the sinks for the configured outputs. Prometheus exporters are served
+// as they're created, and will scrape the given collectors on demand; if
+// they're the only outputs, pullOnly is true and there's no need to run the
+// collection loops at all. If we're running them anyway for other sinks, the
+// exporters get fed from them too, and only scrape a router themselves if its
+// loop hasn't delivered a sample for longer than its interval and timeout
+// together (or the cache time, if that's longer).
+//
+// If there's a history store, it's a sink too, and the dashboard shows what's
+// in it rather than keeping its own history in memory. So is the alert engine,
+// if there are any rules, which is last so that it can pass alerts on to the
+// rest.
+//
+// Every sink is instrumented, so the monitor knows how it's doing. Sinks are
+// named for their type, with a number added if there's more than one, and the
+// monitor forgets about any others.
+//
+// The servers for Prometheus and the dashboard are returned, but not started,
+// since the old ones may still be listening on the same addresses when the
+// configuration's being reloaded. If any of the sinks can't be created, the
+// ones that were are closed again, and the monitor is left alone.
+func createSinks(outputs []config.Output, alerts config.Alerts, collectors []*collector, monitor *health.Monitor, store *history.Store) (sinks sink.Multi, servers []*http.Server, pullOnly bool, err error) {
+	pullOnly = len(outputs) > 0 && store == nil
+	seen := make(map[string]int)
+	var exporters []*prometheus.Exporter
+	var names []string
+	spools := make(map[string]*spool.Spool)
+	defer func() {
+		if err != nil {
+			sinks.Close()
+			sinks, servers = nil, nil
+			return
+		}
+		monitor.SetSinks(names)
+		for name, s := range spools {
+			monitor.WatchSpool(name, s.Len)
+		}
+	}()
+
+	register := func(name string, s sink.Sink) {
+		sinks = append(sinks, health.Instrument(monitor, name, s))
+		names = append(names, name)
+	}
+
+	if store != nil {
+		register("history", store)
+	}
+
+	for _, output := range outputs {
+		seen[output.Type]++
+		name := output.Type
+		if seen[output.Type] > 1 {
+			name = fmt.Sprintf("%s-%d", output.Type, seen[output.Type])
+		}
+		add := func(s sink.Sink) {
+			register(name, s)
+		}
+
+		switch output.Type {
+		case "insights":
+			client := insights.NewClient(output.Account, output.APIKey)
+			client.SetTimeout(time.Duration(output.Timeout))
+			if output.Endpoint != "" {
+				client.Endpoint = output.Endpoint
+			} else {
+				// Validation has already made sure this is a known region.
+				client.Endpoint, _ = insights.RegionEndpoint(output.Region)
+			}
+
+			s := &insights.Sink{Client: client}
+			if output.Spool != "" {
+				if s.Spool, err = spool.Open(output.Spool, output.SpoolSize); err != nil {
+					return sinks, servers, pullOnly, fmt.Errorf("Error opening Insights spool: %w", err)
+				}
+				spools[name] = s.Spool
+			}
+
+			add(s)
+			pullOnly = false
+
+		case "influx":
+			if output.URL != "" {
+				s := influx.NewHTTPSink(output.URL)
+				s.SetTimeout(time.Duration(output.Timeout))
+				add(s)
+			} else if output.File == "-" {
+				add(influx.NewWriterSink(os.Stdout))
+			} else {
+				s, err := influx.NewFileSink(output.File)
+				if err != nil {
+					return sinks, servers, pullOnly, fmt.Errorf("Error opening InfluxDB output file: %w", err)
+				}
+
+				add(s)
+			}
+			pullOnly = false
+
+		case "prometheus":
+			// Serving metrics from a replay doesn't make any sense.
+			if collectors == nil {
+				continue
+			}
+
+			exporter := prometheus.NewExporter(time.Duration(output.Cache))
+			exporter.SetMonitor(monitor)
+			for _, coll := range collectors {
+				exporter.AddRouter(coll.router.Name, coll.Collect)
+			}
+			exporters = append(exporters, exporter)
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", exporter)
+			served := serve(exporter, output.Listen, mux)
+			servers = append(servers, served.server)
+			add(served)
+
+			log.Printf("Serving Prometheus metrics on %s", output.Listen)
+
+		case "dashboard":
+			// Nor does a dashboard that goes away as soon as the replay's done.
+			if collectors == nil {
+				continue
+			}
+
+			var served *servedSink
+			if store != nil {
+				served = serve(nullSink{}, output.Listen, dashboard.New(store))
+			} else {
+				h := history.NewMemory(time.Duration(output.Retention))
+				served = serve(h, output.Listen, dashboard.New(h))
+			}
+			servers = append(servers, served.server)
+			add(served)
+			pullOnly = false
+
+			log.Printf("Serving the dashboard on %s", output.Listen)
+		}
+	}
+
+	// Alerting on something that happened weeks ago isn't much use either.
+	if len(alerts.Rules) > 0 && collectors != nil {
+		engine := createAlerts(alerts, sinks)
+
+		// Give the slowest router a few chances before its alerts go stale.
+		var longest time.Duration
+		for _, coll := range collectors {
+			if interval := time.Duration(coll.router.Interval); interval > longest {
+				longest = interval
+			}
+		}
+		engine.SetStale(3 * longest)
+
+		register("alerts", engine)
+		pullOnly = false
+	}
+
+	// A sample from the loop is the age of the interval just before the next
+	// one arrives, and the collection itself can take up to the timeout, so
+	// anything younger than both together is as fresh as it's going to get.
+	if !pullOnly {
+		for _, exporter := range exporters {
+			for _, coll := range collectors {
+				ttl := time.Duration(coll.router.Interval + coll.router.Timeout)
+				if cache := exporter.TTL(); cache > ttl {
+					ttl = cache
+				}
+				exporter.SetTTL(coll.router.Name, ttl)
+			}
+		}
+	}
+
+	return
+}
+
+// Creates the alert engine for the configured rules. The sinks are only used
+// by a sinks notifier, which sends alerts to them as events. The engine starts
+// out knowing nothing, so reloading the configuration drops whatever the old
+// one had firing.
+func createAlerts(cfg config.Alerts, sinks sink.Multi) *alert.Engine {
+	rules := make([]alert.Rule, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		rules[i] = alert.Rule{
+			Name:       r.Name,
+			Field:      r.Field,
+			Hysteresis: r.Hysteresis,
+			For:        time.Duration(r.For),
+			Over:       time.Duration(r.Over),
+		}
+
+		// Validation has already made sure there's exactly one of these.
+		if r.Above != nil {
+			rules[i].Op = alert.Above
+			rules[i].Threshold = *r.Above
+		} else {
+			rules[i].Op = alert.Below
+			rules[i].Threshold = *r.Below
+		}
+	}
+
+	var notifiers []alert.Notifier
+	for _, n := range cfg.Notifiers {
+		switch n.Type {
+		case "webhook":
+			notifiers = append(notifiers, alert.NewWebhook(n.URL, time.Duration(n.Timeout)))
+		case "smtp":
+			notifiers = append(notifiers, &alert.SMTP{
+				Addr:     n.Addr,
+				From:     n.From,
+				To:       n.To,
+				Username: n.Username,
+				Password: n.Password,
+				Timeout:  time.Duration(n.Timeout),
+			})
+		case "sinks":
+			notifiers = append(notifiers, &alert.Sinks{Sinks: sinks})
+		}
+	}
+
+	return alert.NewEngine(rules, notifiers...)
+}
+
+// Opens the history store, if one's configured.
+func openHistory(cfg config.History) (*history.Store, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+
+	return history.Open(cfg.Dir, history.Retention{
+		Raw:    time.Duration(cfg.RawRetention),
+		Hourly: time.Duration(cfg.HourlyRetention),
+		Daily:  time.Duration(cfg.DailyRetention),
+	})
+}
+
+// For outputs that don't need to be sent anything themselves, since they're
+// served from something else that is.
+type nullSink struct{}
+
+func (nullSink) Send(*sink.Sample) error     { return nil }
+func (nullSink) SendEvent(*sink.Event) error { return nil }
+
+// A sink along with the server it's being served by, so that the server gets
+// shut down along with the rest of the sinks.
+type servedSink struct {
+	sink.Sink
+	server *http.Server
+}
+
+// The server isn't started; see listen.
+func serve(s sink.Sink, listen string, handler http.Handler) *servedSink {
+	return &servedSink{s, &http.Server{Addr: listen, Handler: handler}}
+}
+
+// Gives any in flight requests a few seconds to finish.
+func (s *servedSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
+}
+
+// Send every sample in a capture file to the sinks, as fast as they'll take
+// them. Samples that can't be parsed are logged and skipped, since finding
+// those is half the point of captures.
+func replay(path string, sinks sink.Multi) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	replayer := capture.NewReplayer(f)
+
+	// A capture can have several routers in it, and each needs its own, just
+	// as each collector has its own when collecting.
+	trackers := make(map[string]*tracker)
+	for {
+		sample, err := replayer.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			if _, ok := err.(*capture.ParseError); !ok {
+				return err
+			}
+
+			log.Print(err)
+			continue
+		}
+
+		t := trackers[sample.Router]
+		if t == nil {
+			t = newTracker()
+			trackers[sample.Router] = t
+		}
+		events := t.process(sample)
+
+		log.Printf("Replaying sample from %v...", sample.Time)
+		if err := sinks.Send(sample); err != nil {
+			log.Printf("Error sending data to sinks: %v", err)
+		}
+
+		sendEvents(sinks, events)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"capture"
+	"io/ioutil"
+	"os"
+	"sink"
+	"sync"
+	"testing"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	samples []*sink.Sample
+}
+
+func (s *recordingSink) Send(sample *sink.Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = append(s.samples, sample)
+	return nil
+}
+
+func (s *recordingSink) SendEvent(event *sink.Event) error {
+	return nil
+}
+
+func TestReplayRouters(t *testing.T) {
+	data, err := ioutil.ReadFile("src/actiontec/testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := string(data)
+
+	// Two samples from each of two routers, interleaved.
+	buffer := new(bytes.Buffer)
+	writers := []*capture.Writer{
+		capture.NewWriter(buffer, sink.Source{Router: "home"}),
+		capture.NewWriter(buffer, sink.Source{Router: "office"}),
+	}
+	for i := 0; i < 4; i++ {
+		w := writers[i%2]
+		w.Record(0, payload)
+		w.Record(1, payload)
+	}
+
+	f, err := ioutil.TempFile("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(buffer.Bytes())
+	f.Close()
+
+	s := new(recordingSink)
+	if err := replay(f.Name(), sink.Multi{s}); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if len(s.samples) != 4 {
+		t.Fatalf("Expected 4 samples; got %d", len(s.samples))
+	}
+
+	// Each router's first sample has nothing to be compared with.
+	for i, sample := range s.samples {
+		if (sample.Delta == nil) != (i < 2) {
+			t.Errorf("Sample %d from %s: unexpected delta %+v", i, sample.Router, sample.Delta)
+		}
+	}
+}
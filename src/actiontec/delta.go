@@ -0,0 +1,123 @@
+package actiontec
+
+// Most of the counters in the status are cumulative since the modem booted,
+// which makes them close to useless on a graph. These functions turn two
+// successive statuses into the change over the interval between them.
+
+import (
+	"math"
+	"time"
+)
+
+// The change in the cumulative counters between two statuses. If Reset is
+// true, the modem rebooted during the interval, and the values are the counts
+// since the reboot (which is the best we can do). LineReset is the same for
+// the line error counters (CRC through SES), which also start again when the
+// line retrains, even though the modem stays up.
+type Delta struct {
+	Interval               time.Duration `json:"interval"`
+	Reset                  bool          `json:"reset"`
+	LineReset              bool          `json:"line_reset"`
+	Packets                PacketPair    `json:"packets"`
+	Failures               LinkFailures  `json:"failures"`
+	Retrains               uint64        `json:"retrains"`
+	UnavailableSeconds     time.Duration `json:"unavailable_seconds"`
+	CRC                    EndCounters   `json:"crc"`
+	FEC                    EndCounters   `json:"fec"`
+	HEC                    NearFarPair   `json:"hec"`
+	ErroredSeconds         NearFarPair   `json:"errored_seconds"`
+	SeverelyErroredSeconds NearFarPair   `json:"severely_errored_seconds"`
+}
+
+// Calculate the change between two statuses taken interval apart.
+func Diff(before, after *Status, interval time.Duration) *Delta {
+	d := &Delta{
+		Interval: interval,
+		Reset:    after.ModemUptime < before.ModemUptime,
+	}
+	d.LineReset = d.Reset ||
+		after.LineStats.Uptime < before.LineStats.Uptime ||
+		after.LineStats.Retrains < before.LineStats.Retrains
+	diff := func(b, a uint64) uint64 {
+		return counterDiff(b, a, d.Reset)
+	}
+
+	d.Packets.Received.Count = diff(before.Packets.Received.Count, after.Packets.Received.Count)
+	d.Packets.Received.Errors = diff(before.Packets.Received.Errors, after.Packets.Received.Errors)
+	d.Packets.Transmitted.Count = diff(before.Packets.Transmitted.Count, after.Packets.Transmitted.Count)
+	d.Packets.Transmitted.Errors = diff(before.Packets.Transmitted.Errors, after.Packets.Transmitted.Errors)
+
+	d.Failures.Power = diff(before.Failures.Power, after.Failures.Power)
+	d.Failures.Signal = diff(before.Failures.Signal, after.Failures.Signal)
+	d.Failures.Margin = diff(before.Failures.Margin, after.Failures.Margin)
+	d.Failures.Train = diff(before.Failures.Train, after.Failures.Train)
+
+	d.Retrains = diff(before.TotalRetrains, after.TotalRetrains)
+	d.UnavailableSeconds = time.Duration(diff(uint64(before.UnavailableSeconds.Seconds()), uint64(after.UnavailableSeconds.Seconds()))) * time.Second
+
+	d.CRC = endCountersDiff(before.Errors.CRC, after.Errors.CRC, d.LineReset)
+	d.FEC = endCountersDiff(before.Errors.FEC, after.Errors.FEC, d.LineReset)
+	d.HEC = nearFarDiff(before.Errors.HEC, after.Errors.HEC, d.LineReset)
+	d.ErroredSeconds = nearFarDiff(before.Errors.ErroredSeconds, after.Errors.ErroredSeconds, d.LineReset)
+	d.SeverelyErroredSeconds = nearFarDiff(before.Errors.SeverelyErroredSeconds, after.Errors.SeverelyErroredSeconds, d.LineReset)
+
+	return d
+}
+
+// Convert a count over the interval into a per second rate.
+func (d *Delta) PerSecond(count uint64) float64 {
+	if d.Interval <= 0 {
+		return 0
+	}
+
+	return float64(count) / d.Interval.Seconds()
+}
+
+// How close to its maximum a counter has to have been for going backwards to
+// count as wrapping around, rather than being reset.
+const wrapMargin = 1 << 28
+
+// The difference between two readings of a counter. If the counter was reset,
+// the new value is all we've got. A counter that went backwards from near the
+// top of its range has wrapped: the modem's counters appear to be 32 bit, so
+// that's checked first, then 64 bit (which unsigned subtraction handles for
+// free). Going backwards from anywhere else means it was reset by something we
+// didn't spot, so that's treated as a reset too, rather than reporting a
+// change of four billion or so.
+func counterDiff(before, after uint64, reset bool) uint64 {
+	if reset {
+		return after
+	}
+	if after >= before {
+		return after - before
+	}
+
+	switch {
+	case before <= math.MaxUint32 && before >= math.MaxUint32-wrapMargin:
+		return after + (math.MaxUint32 - before) + 1
+	case before >= math.MaxUint64-wrapMargin:
+		return after - before
+	}
+
+	return after
+}
+
+func endCountersDiff(before, after EndCounters, reset bool) EndCounters {
+	return EndCounters{
+		Near: PathCounters{
+			counterDiff(before.Near.Interleaved, after.Near.Interleaved, reset),
+			counterDiff(before.Near.Fast, after.Near.Fast, reset),
+		},
+		Far: PathCounters{
+			counterDiff(before.Far.Interleaved, after.Far.Interleaved, reset),
+			counterDiff(before.Far.Fast, after.Far.Fast, reset),
+		},
+	}
+}
+
+func nearFarDiff(before, after NearFarPair, reset bool) NearFarPair {
+	return NearFarPair{
+		counterDiff(before.Near, after.Near, reset),
+		counterDiff(before.Far, after.Far, reset),
+	}
+}
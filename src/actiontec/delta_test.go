@@ -0,0 +1,111 @@
+package actiontec
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounterDiff(t *testing.T) {
+	cases := []struct {
+		before   uint64
+		after    uint64
+		reset    bool
+		expected uint64
+	}{
+		{0, 0, false, 0},
+		{10, 25, false, 15},
+		{10, 25, true, 25},
+		{100, 5, true, 5},
+		{100, 5, false, 5},
+		{math.MaxUint32 - 4, 5, false, 10},
+		{math.MaxUint64 - 4, 5, false, 10},
+	}
+
+	for _, c := range cases {
+		if d := counterDiff(c.before, c.after, c.reset); d != c.expected {
+			t.Errorf("counterDiff(%d, %d, %v): got %d; expected %d", c.before, c.after, c.reset, d, c.expected)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := &Status{
+		TotalRetrains:      2,
+		Failures:           LinkFailures{1, 0, 0, 0},
+		UnavailableSeconds: 60 * time.Second,
+		ModemUptime:        1000 * time.Second,
+		LineStats:          LineStats{Uptime: 900 * time.Second, Retrains: 2},
+		Packets:            PacketPair{Packets{1000, 1}, Packets{2000, 2}},
+		Errors: LineErrors{
+			CRC: EndCounters{PathCounters{10, 0}, PathCounters{20, 0}},
+			HEC: NearFarPair{3, 4},
+		},
+	}
+
+	after := &Status{
+		TotalRetrains:      3,
+		Failures:           LinkFailures{1, 1, 0, 0},
+		UnavailableSeconds: 90 * time.Second,
+		ModemUptime:        1060 * time.Second,
+		LineStats:          LineStats{Uptime: 960 * time.Second, Retrains: 2},
+		Packets:            PacketPair{Packets{1600, 1}, Packets{2300, 5}},
+		Errors: LineErrors{
+			CRC: EndCounters{PathCounters{15, 0}, PathCounters{20, 0}},
+			HEC: NearFarPair{3, 6},
+		},
+	}
+
+	d := Diff(before, after, 60*time.Second)
+
+	if d.Reset || d.LineReset {
+		t.Errorf("Unexpected reset")
+	}
+
+	if d.Packets != (PacketPair{Packets{600, 0}, Packets{300, 3}}) {
+		t.Errorf("Invalid packets: got %v", d.Packets)
+	}
+
+	if d.Failures != (LinkFailures{0, 1, 0, 0}) || d.Retrains != 1 {
+		t.Errorf("Invalid failures or retrains: got %v, %d", d.Failures, d.Retrains)
+	}
+
+	if d.UnavailableSeconds != 30*time.Second {
+		t.Errorf("Invalid unavailable seconds: got %v", d.UnavailableSeconds)
+	}
+
+	if d.CRC.Near.Interleaved != 5 || d.HEC != (NearFarPair{0, 2}) {
+		t.Errorf("Invalid errors: got %v, %v", d.CRC, d.HEC)
+	}
+
+	if rate := d.PerSecond(d.Packets.Received.Count); rate != 10 {
+		t.Errorf("Invalid rate: got %v", rate)
+	}
+
+	// Retraining resets the line's error counters, but nothing else.
+	after.LineStats = LineStats{Uptime: 10 * time.Second, Retrains: 3}
+	after.Errors.CRC.Near.Interleaved = 4
+	d = Diff(before, after, 60*time.Second)
+
+	if d.Reset || !d.LineReset {
+		t.Errorf("Expected only a line reset: %+v", d)
+	}
+	if d.CRC.Near.Interleaved != 4 || d.HEC != (NearFarPair{3, 6}) {
+		t.Errorf("Invalid errors after a retrain: got %v, %v", d.CRC, d.HEC)
+	}
+	if d.Retrains != 1 || d.Packets.Received.Count != 600 {
+		t.Errorf("Unexpected delta after a retrain: %+v", d)
+	}
+
+	// Rebooting resets everything.
+	after.ModemUptime = 30 * time.Second
+	d = Diff(before, after, 60*time.Second)
+
+	if !d.Reset || d.Packets.Received.Count != 1600 || d.Retrains != 3 {
+		t.Errorf("Unexpected delta after reset: %+v", d)
+	}
+
+	if rate := (&Delta{}).PerSecond(10); rate != 0 {
+		t.Errorf("Expected a zero interval to have a zero rate; got %v", rate)
+	}
+}
@@ -0,0 +1,229 @@
+package actiontec
+
+// Encodings for the public types, so that statuses can be written to files and
+// read back, or handed to other tools.
+//
+// The encodings are stable: keys are the snake case names given in the struct
+// tags, enums are encoded as their names (the same strings the router uses for
+// states), and durations are encoded as a number of seconds.
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func (ct ChannelType) MarshalText() ([]byte, error) {
+	if ct != Interleaved && ct != FastChannel {
+		return nil, fmt.Errorf("Unknown channel type: %d", int(ct))
+	}
+
+	return []byte(ct.String()), nil
+}
+
+func (ct *ChannelType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "Interleaved":
+		*ct = Interleaved
+	case "Fast":
+		*ct = FastChannel
+	default:
+		return fmt.Errorf("Unknown channel type: %s", text)
+	}
+
+	return nil
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	if s != Up && s != EstablishingLink && s != Down {
+		return nil, fmt.Errorf("Unknown state: %d", int(s))
+	}
+
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) (err error) {
+	*s, err = stringToState(string(text))
+	return
+}
+
+// Durations are awkward: time.Duration encodes as nanoseconds, and it's not
+// our type, so it can't be taught otherwise. Instead, the types with durations
+// in them are encoded via a mirror of themselves with the durations swapped
+// for seconds.
+
+func toSeconds(d time.Duration) float64 {
+	return d.Seconds()
+}
+
+func fromSeconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+type lineStatsEncoding struct {
+	State             State     `json:"state"`
+	Rates             Rates     `json:"rates"`
+	SignalNoiseMargin UintPair  `json:"signal_noise_margin"`
+	Attenuation       FloatPair `json:"attenuation"`
+	Retrains          uint64    `json:"retrains"`
+	Uptime            float64   `json:"uptime"`
+}
+
+func (ls LineStats) encoding() *lineStatsEncoding {
+	return &lineStatsEncoding{
+		State:             ls.State,
+		Rates:             ls.Rates,
+		SignalNoiseMargin: ls.SignalNoiseMargin,
+		Attenuation:       ls.Attenuation,
+		Retrains:          ls.Retrains,
+		Uptime:            toSeconds(ls.Uptime),
+	}
+}
+
+func (e *lineStatsEncoding) decode() LineStats {
+	return LineStats{
+		State:             e.State,
+		Rates:             e.Rates,
+		SignalNoiseMargin: e.SignalNoiseMargin,
+		Attenuation:       e.Attenuation,
+		Retrains:          e.Retrains,
+		Uptime:            fromSeconds(e.Uptime),
+	}
+}
+
+func (ls LineStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ls.encoding())
+}
+
+func (ls *LineStats) UnmarshalJSON(data []byte) error {
+	e := new(lineStatsEncoding)
+	if err := json.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	*ls = e.decode()
+	return nil
+}
+
+type statusEncoding struct {
+	TotalRate          Rates        `json:"total_rate"`
+	SoftwareVersion    string       `json:"software_version"`
+	LineStats          LineStats    `json:"line_stats"`
+	TotalRetrains      uint64       `json:"total_retrains"`
+	Failures           LinkFailures `json:"failures"`
+	UnavailableSeconds float64      `json:"unavailable_seconds"`
+	ChannelType        ChannelType  `json:"channel_type"`
+	ModemUptime        float64      `json:"modem_uptime"`
+	Packets            PacketPair   `json:"packets"`
+	Errors             LineErrors   `json:"errors"`
+	LineRates          []LineRate   `json:"line_rates"`
+}
+
+func (s Status) encoding() *statusEncoding {
+	return &statusEncoding{
+		TotalRate:          s.TotalRate,
+		SoftwareVersion:    s.SoftwareVersion,
+		LineStats:          s.LineStats,
+		TotalRetrains:      s.TotalRetrains,
+		Failures:           s.Failures,
+		UnavailableSeconds: toSeconds(s.UnavailableSeconds),
+		ChannelType:        s.ChannelType,
+		ModemUptime:        toSeconds(s.ModemUptime),
+		Packets:            s.Packets,
+		Errors:             s.Errors,
+		LineRates:          s.LineRates,
+	}
+}
+
+func (e *statusEncoding) decode() Status {
+	return Status{
+		TotalRate:          e.TotalRate,
+		SoftwareVersion:    e.SoftwareVersion,
+		LineStats:          e.LineStats,
+		TotalRetrains:      e.TotalRetrains,
+		Failures:           e.Failures,
+		UnavailableSeconds: fromSeconds(e.UnavailableSeconds),
+		ChannelType:        e.ChannelType,
+		ModemUptime:        fromSeconds(e.ModemUptime),
+		Packets:            e.Packets,
+		Errors:             e.Errors,
+		LineRates:          e.LineRates,
+	}
+}
+
+func (s Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.encoding())
+}
+
+func (s *Status) UnmarshalJSON(data []byte) error {
+	e := new(statusEncoding)
+	if err := json.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	*s = e.decode()
+	return nil
+}
+
+type deltaEncoding struct {
+	Interval               float64      `json:"interval"`
+	Reset                  bool         `json:"reset"`
+	LineReset              bool         `json:"line_reset"`
+	Packets                PacketPair   `json:"packets"`
+	Failures               LinkFailures `json:"failures"`
+	Retrains               uint64       `json:"retrains"`
+	UnavailableSeconds     float64      `json:"unavailable_seconds"`
+	CRC                    EndCounters  `json:"crc"`
+	FEC                    EndCounters  `json:"fec"`
+	HEC                    NearFarPair  `json:"hec"`
+	ErroredSeconds         NearFarPair  `json:"errored_seconds"`
+	SeverelyErroredSeconds NearFarPair  `json:"severely_errored_seconds"`
+}
+
+func (d Delta) encoding() *deltaEncoding {
+	return &deltaEncoding{
+		Interval:               toSeconds(d.Interval),
+		Reset:                  d.Reset,
+		LineReset:              d.LineReset,
+		Packets:                d.Packets,
+		Failures:               d.Failures,
+		Retrains:               d.Retrains,
+		UnavailableSeconds:     toSeconds(d.UnavailableSeconds),
+		CRC:                    d.CRC,
+		FEC:                    d.FEC,
+		HEC:                    d.HEC,
+		ErroredSeconds:         d.ErroredSeconds,
+		SeverelyErroredSeconds: d.SeverelyErroredSeconds,
+	}
+}
+
+func (e *deltaEncoding) decode() Delta {
+	return Delta{
+		Interval:               fromSeconds(e.Interval),
+		Reset:                  e.Reset,
+		LineReset:              e.LineReset,
+		Packets:                e.Packets,
+		Failures:               e.Failures,
+		Retrains:               e.Retrains,
+		UnavailableSeconds:     fromSeconds(e.UnavailableSeconds),
+		CRC:                    e.CRC,
+		FEC:                    e.FEC,
+		HEC:                    e.HEC,
+		ErroredSeconds:         e.ErroredSeconds,
+		SeverelyErroredSeconds: e.SeverelyErroredSeconds,
+	}
+}
+
+func (d Delta) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.encoding())
+}
+
+func (d *Delta) UnmarshalJSON(data []byte) error {
+	e := new(deltaEncoding)
+	if err := json.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	*d = e.decode()
+	return nil
+}
@@ -0,0 +1,165 @@
+package actiontec
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusJSONRoundTrip(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := ParseStatus(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	for _, expected := range []string{
+		`"total_rate":{"up":20000,"down":100000}`,
+		`"channel_type":"Interleaved"`,
+		`"modem_uptime":5000`,
+		`"unavailable_seconds":60`,
+		`"state":"Up"`,
+		`"uptime":1000`,
+		`"line_rates":[{"rates":{"up":10000,"down":50000},"state":"Up"}`,
+		`"crc":{"near":{"interleaved":12,"fast":0}`,
+	} {
+		if !strings.Contains(string(encoded), expected) {
+			t.Errorf("Expected %s in %s", expected, encoded)
+		}
+	}
+
+	decoded := new(Status)
+	if err := json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if !reflect.DeepEqual(status, decoded) {
+		t.Errorf("Status didn't survive the round trip: got %+v; expected %+v", decoded, status)
+	}
+}
+
+func TestDeltaJSONRoundTrip(t *testing.T) {
+	delta := &Delta{
+		Interval:           1500 * time.Millisecond,
+		Reset:              true,
+		Retrains:           2,
+		UnavailableSeconds: 30 * time.Second,
+		HEC:                NearFarPair{Near: 5},
+	}
+
+	encoded, err := json.Marshal(delta)
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if !strings.Contains(string(encoded), `"interval":1.5,"reset":true`) {
+		t.Errorf("Unexpected encoding: %s", encoded)
+	}
+
+	decoded := new(Delta)
+	if err := json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if *decoded != *delta {
+		t.Errorf("Delta didn't survive the round trip: got %+v; expected %+v", decoded, delta)
+	}
+}
+
+// The encodings mirror the public types by hand, so this checks that they
+// haven't drifted: every field has to be on both sides, in the same order,
+// with the same type (or a float64 of seconds for a duration).
+func TestEncodingFields(t *testing.T) {
+	cases := []struct {
+		public, encoding interface{}
+	}{
+		{LineStats{}, lineStatsEncoding{}},
+		{Status{}, statusEncoding{}},
+		{Delta{}, deltaEncoding{}},
+	}
+
+	duration := reflect.TypeOf(time.Duration(0))
+	seconds := reflect.TypeOf(float64(0))
+
+	for _, c := range cases {
+		public, encoding := reflect.TypeOf(c.public), reflect.TypeOf(c.encoding)
+		if public.NumField() != encoding.NumField() {
+			t.Errorf("%s has %d fields but %s has %d", public, public.NumField(), encoding, encoding.NumField())
+			continue
+		}
+
+		for i := 0; i < public.NumField(); i++ {
+			pf, ef := public.Field(i), encoding.Field(i)
+			if pf.Name != ef.Name {
+				t.Errorf("%s field %d is %s but %s field %d is %s", public, i, pf.Name, encoding, i, ef.Name)
+				continue
+			}
+			if pf.Tag.Get("json") != ef.Tag.Get("json") {
+				t.Errorf("%s.%s is tagged %q but %s.%s is tagged %q", public, pf.Name, pf.Tag.Get("json"), encoding, ef.Name, ef.Tag.Get("json"))
+			}
+			if pf.Type != ef.Type && !(pf.Type == duration && ef.Type == seconds) {
+				t.Errorf("%s.%s is a %s but %s.%s is a %s", public, pf.Name, pf.Type, encoding, ef.Name, ef.Type)
+			}
+		}
+	}
+}
+
+func TestEnumText(t *testing.T) {
+	successCases := []string{
+		`{"state":"Up","channel_type":"Interleaved"}`,
+		`{"state":"EstablishingLink","channel_type":"Fast"}`,
+		`{"state":"Down","channel_type":"Fast"}`,
+	}
+
+	for _, c := range successCases {
+		var v struct {
+			State       State       `json:"state"`
+			ChannelType ChannelType `json:"channel_type"`
+		}
+
+		if err := json.Unmarshal([]byte(c), &v); err != nil {
+			t.Errorf("Got an error when one wasn't expected")
+		}
+
+		encoded, err := json.Marshal(&v)
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected")
+		}
+		if string(encoded) != c {
+			t.Errorf("Invalid encoding: got %s; expected %s", encoded, c)
+		}
+	}
+
+	errorCases := []string{
+		`{"state":"Sideways"}`,
+		`{"state":0}`,
+		`{"channel_type":"Slow"}`,
+	}
+
+	for _, c := range errorCases {
+		var v struct {
+			State       State       `json:"state"`
+			ChannelType ChannelType `json:"channel_type"`
+		}
+
+		if err := json.Unmarshal([]byte(c), &v); err == nil {
+			t.Errorf("Expected an error; got none")
+		}
+	}
+
+	if _, err := json.Marshal(State(42)); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
@@ -3,21 +3,52 @@ package actiontec
 // Screen scraping functions for the Actiontec UI live here.
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Everything revolves around this context thing, which is basically just a
 // logged in http.Client object and a host name or IP address for the router.
+//
+// Not to be confused with context.Context: every exported method has a
+// variant with a Context suffix that takes one of those, which can be used to
+// cancel or bound the whole operation.
 type Context struct {
-	client  *http.Client
-	address string
+	client   *http.Client
+	address  string
+	recorder Recorder
+	profile  *Profile
+}
+
+// Something that wants to see every raw refresh payload before it's parsed,
+// along with the line that was selected when it was requested.
+type Recorder interface {
+	Record(line int, payload string) error
+}
+
+// How long to wait for the router before giving up. Dial covers establishing
+// the TCP connection; Response covers waiting for the response headers once
+// the request has been sent. Zero means no timeout.
+type Timeouts struct {
+	Dial     time.Duration
+	Response time.Duration
+}
+
+// The web server on these things is slow, but not this slow. If it takes
+// longer than this, it's wedged.
+var DefaultTimeouts = Timeouts{
+	Dial:     10 * time.Second,
+	Response: 30 * time.Second,
 }
 
 // Create a context, but don't log in.
@@ -35,20 +66,67 @@ func NewContext(address string) (*Context, error) {
 	c.client = &http.Client{
 		Jar: jar,
 	}
+	c.SetTimeouts(DefaultTimeouts)
 
 	c.address = address
 
 	return c, nil
 }
 
-// Gather line and overall stats and return them. If you haven't logged in via
-// Login(), you'll have to before this function will work.
+// Returned by Login when the router rejects the user name or password. Unlike
+// most errors, retrying isn't going to help.
+var ErrBadCredentials = errors.New("User name or password incorrect")
+
+// Returned by GetStatus when the router responded, but we couldn't make sense
+// of what it sent. That's a bug (or a new firmware), rather than the router
+// being unreachable, so it's useful to be able to tell the difference.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Error parsing status for line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Set a recorder to be handed every raw payload. Pass nil to stop recording.
+func (c *Context) SetRecorder(r Recorder) {
+	c.recorder = r
+}
+
+// Set the profile used to parse payloads, for firmware that can't be detected
+// automatically. Pass nil to go back to picking one based on the firmware.
+func (c *Context) SetProfile(p *Profile) {
+	c.profile = p
+}
+
+// Replace the timeouts used for talking to the router.
+func (c *Context) SetTimeouts(t Timeouts) {
+	dialer := &net.Dialer{Timeout: t.Dial}
+
+	c.client.Transport = &http.Transport{
+		DialContext:           dialer.DialContext,
+		ResponseHeaderTimeout: t.Response,
+	}
+}
+
+// Gather line and overall stats and return them. The line stats are indexed by
+// line number. If you haven't logged in via Login(), you'll have to before this
+// function will work.
 func (c *Context) GetStatus() (*Status, []LineStats, error) {
+	return c.GetStatusContext(context.Background())
+}
+
+func (c *Context) GetStatusContext(ctx context.Context) (*Status, []LineStats, error) {
 	var ls []LineStats
 
 	// Get the first line, since we need it to figure out how many more lines
 	// there are.
-	status, err := c.statusForLine(0)
+	status, err := c.statusForLine(ctx, 0)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -63,7 +141,7 @@ func (c *Context) GetStatus() (*Status, []LineStats, error) {
 		// lies: you _must_ reload the entire page before the refresh API (which is
 		// as close as the router gets to a REST API) gives you data for the right
 		// line.
-		lineStatus, err := c.statusForLine(1)
+		lineStatus, err := c.statusForLine(ctx, i)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -76,7 +154,11 @@ func (c *Context) GetStatus() (*Status, []LineStats, error) {
 
 // Log into the UI.
 func (c *Context) Login(username string, password string) error {
-	resp, err := c.client.PostForm(c.url("/login.cgi"), url.Values{
+	return c.LoginContext(context.Background(), username, password)
+}
+
+func (c *Context) LoginContext(ctx context.Context, username string, password string) error {
+	resp, err := c.postForm(ctx, "/login.cgi", url.Values{
 		"inputUserName": []string{username},
 		"inputPassword": []string{password},
 		"nothankyou":    []string{"1"},
@@ -95,14 +177,18 @@ func (c *Context) Login(username string, password string) error {
 	// After login, the UI redirects via JavaScript to the appropriate page. We
 	// have to sniff the string that would show the error message.
 	if strings.Contains(string(data), "msg=err") {
-		return errors.New("User name or password incorrect")
+		return ErrBadCredentials
 	}
 
 	return err
 }
 
 func (c *Context) Logout() error {
-	resp, err := c.client.PostForm(c.url("/logout.cgi"), url.Values{})
+	return c.LogoutContext(context.Background())
+}
+
+func (c *Context) LogoutContext(ctx context.Context) error {
+	resp, err := c.postForm(ctx, "/logout.cgi", url.Values{})
 	if err != nil {
 		return err
 	}
@@ -116,8 +202,13 @@ func (c *Context) Logout() error {
 
 // Calls the refresh status page, which is a plain text API. See status.go for
 // more details on how that's parsed.
-func (c *Context) refreshStatus() (string, error) {
-	resp, err := c.client.Get(c.url("/modemstatus_wanstatus_refresh.html"))
+func (c *Context) refreshStatus(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url("/modemstatus_wanstatus_refresh.html"), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -134,35 +225,72 @@ func (c *Context) refreshStatus() (string, error) {
 
 // Calls the top level WAN status page, which is required to reset which line
 // we care about.
-func (c *Context) requestStatus(line int) error {
-	_, err := c.client.PostForm(c.url("/modemstatus_wanstatus.cgi"), url.Values{
+func (c *Context) requestStatus(ctx context.Context, line int) error {
+	resp, err := c.postForm(ctx, "/modemstatus_wanstatus.cgi", url.Values{
 		"bondingLineNum": []string{strconv.Itoa(line)},
 	})
+	if err != nil {
+		return err
+	}
 
-	// Don't care about the content.
-	return err
+	// Don't care about the content, but it does need to be read and closed for
+	// the connection to be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	return nil
 }
 
-func (c *Context) statusForLine(line int) (*Status, error) {
+func (c *Context) statusForLine(ctx context.Context, line int) (*Status, error) {
 	// See the comment in GetStatus() for why we always have to perform this two
 	// step dance instead of just calling refreshStatus().
-	if err := c.requestStatus(line); err != nil {
+	if err := c.requestStatus(ctx, line); err != nil {
 		return nil, err
 	}
 
-	data, err := c.refreshStatus()
+	data, err := c.refreshStatus(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	status, err := ParseStatus(data)
+	// Record before parsing, so that payloads we can't parse are captured too.
+	if c.recorder != nil {
+		if err := c.recorder.Record(line, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var status *Status
+	if c.profile != nil {
+		status, err = ParseStatusWithProfile(data, c.profile)
+	} else {
+		status, err = ParseStatus(data)
+	}
 	if err != nil {
-		return nil, err
+		return nil, &ParseError{line, err}
+	}
+
+	// Since the line selection is global state on the router, something else
+	// (another instance of this, or someone with the UI open) can change it
+	// between the two requests above. Make sure we got what we asked for.
+	if !status.IsForLine(line) {
+		return nil, fmt.Errorf("Router returned stats for the wrong line (requested line %d)", line)
 	}
 
 	return status, nil
 }
 
+// Equivalent to http.Client.PostForm, but with a context.
+func (c *Context) postForm(ctx context.Context, rel string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url(rel), strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.client.Do(req)
+}
+
 // Convenience function to build an absolute URL from a relative one, given a
 // context.
 func (c *Context) url(rel string) string {
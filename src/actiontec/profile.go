@@ -0,0 +1,146 @@
+package actiontec
+
+// Different models and firmware versions put things in different places in
+// the refresh payload. Rather than special casing them all over the parser,
+// each layout is described by a profile, and the profile is chosen based on
+// the firmware version in the payload (or explicitly, if the firmware isn't
+// one we know about).
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Where each field lives in the '+' separated refresh payload. Field 0 is
+// never anything we're interested in, so an index of zero means the firmware
+// doesn't report that field at all.
+type Profile struct {
+	// Used to select the profile explicitly, so should be short and stable.
+	Name string
+
+	// SoftwareVersion prefixes this profile handles. The longest matching prefix
+	// across all profiles wins.
+	Firmware []string
+
+	// Payloads with fewer fields than this are rejected outright.
+	MinFields int
+
+	TotalRateUp        int
+	TotalRateDown      int
+	LineStats          int
+	TotalRetrains      int
+	Failures           int // The first of four: power, signal, margin, train.
+	UnavailableSeconds int
+	ChannelType        int
+	ModemUptime        int
+	Packets            int
+	Errors             int // The first of the eleven error counter fields.
+	LineRates          int // The first line rate; they run up to the last field.
+}
+
+// Every firmware seen so far has the software version in the same place,
+// which is just as well, since we need it to pick the profile.
+const softwareVersionField = 3
+
+// The bonded VDSL2 V2200H. Firmware T2200H-31.128L.03 adds a third,
+// comma separated value to the attenuation, which is ignored.
+var T2200H = &Profile{
+	Name:               "T2200H",
+	Firmware:           []string{"T2200H-"},
+	MinFields:          27,
+	TotalRateUp:        1,
+	TotalRateDown:      2,
+	LineStats:          4,
+	TotalRetrains:      5,
+	Failures:           6,
+	UnavailableSeconds: 10,
+	ChannelType:        11,
+	ModemUptime:        12,
+	Packets:            13,
+	Errors:             14,
+	LineRates:          25,
+}
+
+// Used when the firmware doesn't match any profile. The T2200H is what this
+// was written against, so it's the best guess we have.
+var DefaultProfile = T2200H
+
+var profiles = struct {
+	sync.RWMutex
+	list []*Profile
+}{list: []*Profile{T2200H}}
+
+// Add a profile, so that it can be selected by name or firmware version.
+// Profiles are usually registered from an init function.
+func RegisterProfile(p *Profile) error {
+	profiles.Lock()
+	defer profiles.Unlock()
+
+	for _, existing := range profiles.list {
+		if existing.Name == p.Name {
+			return fmt.Errorf("A profile named %s is already registered", p.Name)
+		}
+	}
+
+	profiles.list = append(profiles.list, p)
+	return nil
+}
+
+// Returns the names of every registered profile.
+func ProfileNames() []string {
+	profiles.RLock()
+	defer profiles.RUnlock()
+
+	names := make([]string, len(profiles.list))
+	for i, p := range profiles.list {
+		names[i] = p.Name
+	}
+
+	return names
+}
+
+// Returns the profile with the given name, or nil if there isn't one.
+func LookupProfile(name string) *Profile {
+	profiles.RLock()
+	defer profiles.RUnlock()
+
+	for _, p := range profiles.list {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Returns the profile for the given firmware version, or nil if none of them
+// handle it.
+func ProfileForFirmware(version string) *Profile {
+	profiles.RLock()
+	defer profiles.RUnlock()
+
+	var match *Profile
+	longest := 0
+	for _, p := range profiles.list {
+		for _, prefix := range p.Firmware {
+			if strings.HasPrefix(version, prefix) && len(prefix) > longest {
+				match = p
+				longest = len(prefix)
+			}
+		}
+	}
+
+	return match
+}
+
+// Picks the profile for a payload that's already been split into fields.
+func profileForFields(fields []string) *Profile {
+	if len(fields) > softwareVersionField {
+		if p := ProfileForFirmware(fields[softwareVersionField]); p != nil {
+			return p
+		}
+	}
+
+	return DefaultProfile
+}
@@ -0,0 +1,124 @@
+package actiontec
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadFixture(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile("testdata/" + name + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestProfileForFirmware(t *testing.T) {
+	cases := []struct {
+		firmware string
+		profile  *Profile
+	}{
+		{"T2200H-31.128L.03", T2200H},
+		{"T2200H-31.128L.08", T2200H},
+		{"V1000H-31.30L.55", nil},
+		{"Q1000-1.0", nil},
+		{"", nil},
+	}
+
+	for _, c := range cases {
+		if p := ProfileForFirmware(c.firmware); p != c.profile {
+			t.Errorf("Invalid profile for %q: got %v; expected %v", c.firmware, p, c.profile)
+		}
+	}
+}
+
+// Each profile should parse its own fixture, and pick itself when parsing
+// automatically.
+func TestProfileFixtures(t *testing.T) {
+	successCases := []struct {
+		fixture  string
+		firmware string
+		lines    int
+		uptime   time.Duration
+		hec      NearFarPair
+	}{
+		{"T2200H-synthetic", "T2200H-31.128L.03", 2, 5000 * time.Second, NearFarPair{5, 6}},
+	}
+
+	for _, c := range successCases {
+		status, err := ParseStatus(loadFixture(t, c.fixture))
+		if err != nil {
+			t.Errorf("%s: got an error when one wasn't expected: %v", c.fixture, err)
+			continue
+		}
+
+		if status.SoftwareVersion != c.firmware {
+			t.Errorf("%s: invalid software version: got %v", c.fixture, status.SoftwareVersion)
+		}
+
+		if len(status.LineRates) != c.lines {
+			t.Errorf("%s: invalid number of lines: got %d; expected %d", c.fixture, len(status.LineRates), c.lines)
+		}
+
+		if status.ModemUptime != c.uptime {
+			t.Errorf("%s: invalid modem uptime: got %v; expected %v", c.fixture, status.ModemUptime, c.uptime)
+		}
+
+		if status.Errors.HEC != c.hec {
+			t.Errorf("%s: invalid HEC: got %v; expected %v", c.fixture, status.Errors.HEC, c.hec)
+		}
+
+		if !status.IsForLine(0) {
+			t.Errorf("%s: line stats don't match line 0", c.fixture)
+		}
+	}
+
+	// A payload without the error counters is too short to be a T2200H.
+	fields := strings.Split(loadFixture(t, "T2200H-synthetic"), "+")
+	short := strings.Join(append(fields[:14:14], fields[25:]...), "+")
+	if _, err := ParseStatusWithProfile(short, T2200H); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
+
+func TestRegisterProfile(t *testing.T) {
+	custom := *T2200H
+	custom.Name = "test"
+	custom.Firmware = []string{"T2200H-31.128L.03"}
+
+	if err := RegisterProfile(&custom); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+	// Other tests may be looking profiles up, so the registry has to be put
+	// back the same way it's changed: under the lock.
+	defer func() {
+		profiles.Lock()
+		defer profiles.Unlock()
+
+		for i, p := range profiles.list {
+			if p == &custom {
+				profiles.list = append(profiles.list[:i:i], profiles.list[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	if err := RegisterProfile(&custom); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+
+	if LookupProfile("test") != &custom {
+		t.Errorf("Couldn't look up the new profile")
+	}
+
+	// The longer prefix should win.
+	if p := ProfileForFirmware("T2200H-31.128L.03"); p != &custom {
+		t.Errorf("Invalid profile: got %v", p)
+	}
+	if p := ProfileForFirmware("T2200H-31.128L.08"); p != T2200H {
+		t.Errorf("Invalid profile: got %v", p)
+	}
+}
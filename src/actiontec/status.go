@@ -4,6 +4,7 @@ package actiontec
 
 import (
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +19,17 @@ const (
 	FastChannel
 )
 
+func (ct ChannelType) String() string {
+	switch ct {
+	case Interleaved:
+		return "Interleaved"
+	case FastChannel:
+		return "Fast"
+	}
+
+	return fmt.Sprintf("ChannelType(%d)", int(ct))
+}
+
 type State int
 
 const (
@@ -26,151 +38,254 @@ const (
 	Down
 )
 
+// These are the same strings the router uses.
+func (s State) String() string {
+	switch s {
+	case Up:
+		return "Up"
+	case EstablishingLink:
+		return "EstablishingLink"
+	case Down:
+		return "Down"
+	}
+
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
 // Various structures representing the data we get back in a more structured
 // form. (No pun intended.)
 
 type LinkFailures struct {
-	Power  uint64
-	Signal uint64
-	Margin uint64
-	Train  uint64
+	Power  uint64 `json:"power"`
+	Signal uint64 `json:"signal"`
+	Margin uint64 `json:"margin"`
+	Train  uint64 `json:"train"`
 }
 
 type Packets struct {
-	Count  uint64
-	Errors uint64
+	Count  uint64 `json:"count"`
+	Errors uint64 `json:"errors"`
 }
 
 type PacketPair struct {
-	Received    Packets
-	Transmitted Packets
+	Received    Packets `json:"received"`
+	Transmitted Packets `json:"transmitted"`
+}
+
+// Error counters are reported separately for the interleaved and fast
+// channels, even though only one is ever actually in use.
+type PathCounters struct {
+	Interleaved uint64 `json:"interleaved"`
+	Fast        uint64 `json:"fast"`
+}
+
+// Counters that are reported for both the near end (the modem) and the far end
+// (the DSLAM), split by channel.
+type EndCounters struct {
+	Near PathCounters `json:"near"`
+	Far  PathCounters `json:"far"`
+}
+
+// Counters that are reported for both ends, but not split by channel.
+type NearFarPair struct {
+	Near uint64 `json:"near"`
+	Far  uint64 `json:"far"`
+}
+
+// The line error counters from the bottom half of the status page. The 30
+// minute variants are the counts within the current 30 minute window, rather
+// than since the link came up.
+type LineErrors struct {
+	CRC                    EndCounters `json:"crc"`
+	CRC30Minute            EndCounters `json:"crc_30_minute"`
+	FEC                    EndCounters `json:"fec"`
+	FEC30Minute            EndCounters `json:"fec_30_minute"`
+	HEC                    NearFarPair `json:"hec"`
+	ErroredSeconds         NearFarPair `json:"errored_seconds"`
+	SeverelyErroredSeconds NearFarPair `json:"severely_errored_seconds"`
 }
 
 type FloatPair struct {
-	Up   float64
-	Down float64
+	Up   float64 `json:"up"`
+	Down float64 `json:"down"`
 }
 
 type UintPair struct {
-	Up   uint64
-	Down uint64
+	Up   uint64 `json:"up"`
+	Down uint64 `json:"down"`
 }
 
 type Rates UintPair
 
 type LineRate struct {
-	Rates
-	State State
+	Rates `json:"rates"`
+	State State `json:"state"`
 }
 
 type LineStats struct {
-	State             State
-	Rates             Rates
-	SignalNoiseMargin UintPair
-	Attenuation       FloatPair
-	Retrains          uint64
-	Uptime            time.Duration
+	State             State         `json:"state"`
+	Rates             Rates         `json:"rates"`
+	SignalNoiseMargin UintPair      `json:"signal_noise_margin"`
+	Attenuation       FloatPair     `json:"attenuation"`
+	Retrains          uint64        `json:"retrains"`
+	Uptime            time.Duration `json:"uptime"`
 }
 
-// Interesting bits of the status. I've deliberately omitted things like CRC
-// errors, because they're honestly not that interesting to me. There's
-// duplication around things like line rates, but this is a relatively close
-// mapping to the underlying data structure, which feels like it has grown
-// organically rather than anybody ever thinking about a "design".
+// Interesting bits of the status. There's duplication around things like line
+// rates, but this is a relatively close mapping to the underlying data
+// structure, which feels like it has grown organically rather than anybody
+// ever thinking about a "design".
 type Status struct {
-	TotalRate          Rates
-	SoftwareVersion    string
-	LineStats          LineStats
-	TotalRetrains      uint64
-	Failures           LinkFailures
-	UnavailableSeconds time.Duration
-	ChannelType        ChannelType
-	ModemUptime        time.Duration
-	Packets            PacketPair
-	LineRates          []LineRate
+	TotalRate          Rates         `json:"total_rate"`
+	SoftwareVersion    string        `json:"software_version"`
+	LineStats          LineStats     `json:"line_stats"`
+	TotalRetrains      uint64        `json:"total_retrains"`
+	Failures           LinkFailures  `json:"failures"`
+	UnavailableSeconds time.Duration `json:"unavailable_seconds"`
+	ChannelType        ChannelType   `json:"channel_type"`
+	ModemUptime        time.Duration `json:"modem_uptime"`
+	Packets            PacketPair    `json:"packets"`
+	Errors             LineErrors    `json:"errors"`
+	LineRates          []LineRate    `json:"line_rates"`
 }
 
-// Given a blob of status data, parse into a status object.
-func ParseStatus(input string) (status *Status, err error) {
-	status = new(Status)
-
+// Given a blob of status data, parse into a status object. The layout is
+// picked based on the firmware version in the payload; see Profile.
+func ParseStatus(input string) (*Status, error) {
 	fields := strings.Split(input, "+")
-	if len(fields) < 27 {
-		return nil, fmt.Errorf("Unexpected number of fields: %d", len(fields))
-	}
+	return parseFields(fields, profileForFields(fields))
+}
 
-	status.TotalRate.Up, err = strconv.ParseUint(fields[1], 10, 64)
-	if err != nil {
-		return nil, err
-	}
+// As ParseStatus, but with an explicit profile, for firmware that doesn't
+// match any profile (or matches the wrong one).
+func ParseStatusWithProfile(input string, profile *Profile) (*Status, error) {
+	return parseFields(strings.Split(input, "+"), profile)
+}
 
-	status.TotalRate.Down, err = strconv.ParseUint(fields[2], 10, 64)
-	if err != nil {
-		return nil, err
+func parseFields(fields []string, p *Profile) (status *Status, err error) {
+	if len(fields) < p.MinFields || len(fields) <= p.lastField() {
+		return nil, fmt.Errorf("Unexpected number of fields for %s: %d", p.Name, len(fields))
 	}
 
-	status.SoftwareVersion = fields[3]
+	status = new(Status)
+	status.SoftwareVersion = fields[softwareVersionField]
 
-	status.LineStats, err = stringToLineStats(fields[4])
-	if err != nil {
-		return nil, err
+	// Each of these does nothing if a previous one failed, or if the profile
+	// says the field isn't there, so errors only need checking at the end.
+	parse := func(i int, f func(string) error) {
+		if err == nil && i != 0 {
+			err = f(fields[i])
+		}
 	}
-
-	status.TotalRetrains, err = strconv.ParseUint(fields[5], 10, 64)
-	if err != nil {
-		return nil, err
+	parseUint := func(i int, dst *uint64) {
+		parse(i, func(s string) (err error) {
+			*dst, err = strconv.ParseUint(s, 10, 64)
+			return
+		})
 	}
-
-	status.Failures.Power, err = strconv.ParseUint(fields[6], 10, 64)
-	if err != nil {
-		return nil, err
+	parseDuration := func(i int, dst *time.Duration) {
+		parse(i, func(s string) (err error) {
+			*dst, err = stringSecondsToDuration(s)
+			return
+		})
 	}
 
-	status.Failures.Signal, err = strconv.ParseUint(fields[7], 10, 64)
+	parseUint(p.TotalRateUp, &status.TotalRate.Up)
+	parseUint(p.TotalRateDown, &status.TotalRate.Down)
+	parse(p.LineStats, func(s string) (err error) {
+		status.LineStats, err = stringToLineStats(s)
+		return
+	})
+	parseUint(p.TotalRetrains, &status.TotalRetrains)
+	if p.Failures != 0 {
+		parseUint(p.Failures, &status.Failures.Power)
+		parseUint(p.Failures+1, &status.Failures.Signal)
+		parseUint(p.Failures+2, &status.Failures.Margin)
+		parseUint(p.Failures+3, &status.Failures.Train)
+	}
+	parseDuration(p.UnavailableSeconds, &status.UnavailableSeconds)
+	parse(p.ChannelType, func(s string) (err error) {
+		status.ChannelType, err = stringToChannelType(s)
+		return
+	})
+	parseDuration(p.ModemUptime, &status.ModemUptime)
+	parse(p.Packets, func(s string) (err error) {
+		status.Packets, err = stringToPackets(s)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	status.Failures.Margin, err = strconv.ParseUint(fields[8], 10, 64)
-	if err != nil {
-		return nil, err
+	// The error counter layout hasn't been checked against a real router yet
+	// (see stringsToLineErrors), so if it turns out to be wrong, that mustn't
+	// cost us everything else.
+	if p.Errors != 0 {
+		lineErrors, err := stringsToLineErrors(fields[p.Errors : p.Errors+11])
+		if err != nil {
+			log.Printf("Ignoring the line error counters, which don't match the %s layout: %v", p.Name, err)
+		} else {
+			status.Errors = lineErrors
+		}
 	}
 
-	status.Failures.Train, err = strconv.ParseUint(fields[9], 10, 64)
-	if err != nil {
-		return nil, err
-	}
+	if p.LineRates != 0 {
+		for i := p.LineRates; i < len(fields)-1; i++ {
+			rate, err := stringToLineRate(fields[i])
+			if err != nil {
+				return nil, err
+			}
 
-	status.UnavailableSeconds, err = stringSecondsToDuration(fields[10])
-	if err != nil {
-		return nil, err
+			status.LineRates = append(status.LineRates, rate)
+		}
 	}
 
-	status.ChannelType, err = stringToChannelType(fields[11])
-	if err != nil {
-		return nil, err
-	}
+	return status, nil
+}
 
-	status.ModemUptime, err = stringSecondsToDuration(fields[12])
-	if err != nil {
-		return nil, err
+// The highest index the profile refers to, so we can make sure the payload is
+// long enough before going anywhere near it.
+func (p *Profile) lastField() int {
+	last := softwareVersionField
+	for _, i := range []int{
+		p.TotalRateUp,
+		p.TotalRateDown,
+		p.LineStats,
+		p.TotalRetrains,
+		p.UnavailableSeconds,
+		p.ChannelType,
+		p.ModemUptime,
+		p.Packets,
+	} {
+		if i > last {
+			last = i
+		}
 	}
-
-	status.Packets, err = stringToPackets(fields[13])
-	if err != nil {
-		return nil, err
+	if p.Failures != 0 && p.Failures+3 > last {
+		last = p.Failures + 3
 	}
-
-	for i := 25; i < len(fields)-1; i++ {
-		rate, err := stringToLineRate(fields[i])
-		if err != nil {
-			return nil, err
-		}
-
-		status.LineRates = append(status.LineRates, rate)
+	if p.Errors != 0 && p.Errors+10 > last {
+		last = p.Errors + 10
 	}
 
-	return status, nil
+	return last
+}
+
+// The refresh API doesn't tell us which line LineStats is for, but it does
+// include the rates for every line in LineRates, so we can check that the line
+// stats match the rates for the line we think they're for. This can't catch
+// everything (bonded lines with identical rates look the same), but it catches
+// the router handing back a different line to the one we selected. (Replaying
+// a capture makes the same check, which is why it's exported.)
+func (s *Status) IsForLine(line int) bool {
+	if line < 0 || line >= len(s.LineRates) {
+		// Single line modems may not report any line rates at all, in which case
+		// there's nothing to check against.
+		return line == 0 && len(s.LineRates) == 0
+	}
+
+	rate := s.LineRates[line]
+	return rate.State == s.LineStats.State && rate.Rates == s.LineStats.Rates
 }
 
 // Lots of internal parsing functions below: the top level status information
@@ -182,7 +297,8 @@ func stringToAttenuation(s string) (pair FloatPair, err error) {
 	// Firmware T2200H-31.128L.03 adds a third field, separated by a comma, which
 	// right now we're not interested in (it appears to be an attempt to add the
 	// encapsulation of line 2, except it's a completely out of range value).
-	// We'll ignore it if present.
+	// It's harmless to ignore it on everything else, so we do that regardless of
+	// the profile.
 	fields := strings.Split(strings.Split(s, ",")[0], " /")
 	if len(fields) != 2 {
 		err = fmt.Errorf("Unexpected number of fields in a pair: %d", len(fields))
@@ -292,6 +408,70 @@ func stringToLineStats(s string) (stats LineStats, err error) {
 	return
 }
 
+// Fields 14 to 24 are the error counters, in the same order as the UI displays
+// them: CRC, 30 minute CRC, FEC and 30 minute FEC (each as a near end field
+// followed by a far end field, with each field containing interleaved|fast),
+// then HEC, ES and SES (each as near|far).
+//
+// That order is taken from the UI rather than checked against a response from
+// a real router, and the synthetic fixture in testdata was written to match it,
+// so until a real capture confirms it, parseFields logs and zeroes the counters
+// rather than failing when they don't fit.
+func stringsToLineErrors(fields []string) (lineErrors LineErrors, err error) {
+	if len(fields) != 11 {
+		err = fmt.Errorf("Unexpected number of line error fields: %d", len(fields))
+		return
+	}
+
+	for i, counters := range []*EndCounters{
+		&lineErrors.CRC,
+		&lineErrors.CRC30Minute,
+		&lineErrors.FEC,
+		&lineErrors.FEC30Minute,
+	} {
+		counters.Near, err = stringToPathCounters(fields[i*2])
+		if err != nil {
+			return
+		}
+
+		counters.Far, err = stringToPathCounters(fields[i*2+1])
+		if err != nil {
+			return
+		}
+	}
+
+	lineErrors.HEC, err = stringToNearFarPair(fields[8])
+	if err != nil {
+		return
+	}
+
+	lineErrors.ErroredSeconds, err = stringToNearFarPair(fields[9])
+	if err != nil {
+		return
+	}
+
+	lineErrors.SeverelyErroredSeconds, err = stringToNearFarPair(fields[10])
+
+	return
+}
+
+func stringToNearFarPair(s string) (pair NearFarPair, err error) {
+	fields := strings.Split(s, "|")
+	if len(fields) != 2 {
+		err = fmt.Errorf("Unexpected number of fields in a near/far pair: %d", len(fields))
+		return
+	}
+
+	pair.Near, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+
+	pair.Far, err = strconv.ParseUint(fields[1], 10, 64)
+
+	return
+}
+
 func stringToPackets(s string) (packets PacketPair, err error) {
 	fields := strings.Split(s, "|")
 
@@ -315,6 +495,23 @@ func stringToPackets(s string) (packets PacketPair, err error) {
 	return
 }
 
+func stringToPathCounters(s string) (counters PathCounters, err error) {
+	fields := strings.Split(s, "|")
+	if len(fields) != 2 {
+		err = fmt.Errorf("Unexpected number of fields in path counters: %d", len(fields))
+		return
+	}
+
+	counters.Interleaved, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+
+	counters.Fast, err = strconv.ParseUint(fields[1], 10, 64)
+
+	return
+}
+
 func stringToState(s string) (State, error) {
 	if s == "Up" {
 		return Up, nil
@@ -1,6 +1,8 @@
 package actiontec
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 )
@@ -355,3 +357,211 @@ func TestStringToUintPair(t *testing.T) {
 		}
 	}
 }
+
+// The fixture isn't a capture from a router (hence the name); it's hand-written
+// in the layout the parser expects, with distinct values so that a field read
+// from the wrong place shows up.
+func TestParseStatus(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := ParseStatus(string(data))
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if status.TotalRate != (Rates{20000, 100000}) {
+		t.Errorf("Invalid total rate: got %v", status.TotalRate)
+	}
+
+	if status.SoftwareVersion != "T2200H-31.128L.03" {
+		t.Errorf("Invalid software version: got %v", status.SoftwareVersion)
+	}
+
+	if status.Failures != (LinkFailures{1, 2, 3, 4}) {
+		t.Errorf("Invalid failures: got %v", status.Failures)
+	}
+
+	if status.ModemUptime != time.Duration(5000)*time.Second {
+		t.Errorf("Invalid modem uptime: got %v", status.ModemUptime)
+	}
+
+	if status.Packets != (PacketPair{Packets{1000, 10}, Packets{2000, 20}}) {
+		t.Errorf("Invalid packets: got %v", status.Packets)
+	}
+
+	expectedErrors := LineErrors{
+		CRC:                    EndCounters{PathCounters{12, 0}, PathCounters{34, 0}},
+		CRC30Minute:            EndCounters{PathCounters{1, 0}, PathCounters{2, 0}},
+		FEC:                    EndCounters{PathCounters{560, 0}, PathCounters{78, 0}},
+		FEC30Minute:            EndCounters{PathCounters{9, 0}, PathCounters{4, 0}},
+		HEC:                    NearFarPair{5, 6},
+		ErroredSeconds:         NearFarPair{7, 8},
+		SeverelyErroredSeconds: NearFarPair{1, 2},
+	}
+	if status.Errors != expectedErrors {
+		t.Errorf("Invalid errors: got %v; expected %v", status.Errors, expectedErrors)
+	}
+
+	if len(status.LineRates) != 2 {
+		t.Errorf("Unexpected number of line rates: %d", len(status.LineRates))
+	}
+
+	// Error counters that don't fit the layout are dropped, but everything else
+	// still gets parsed.
+	mismatchCases := []string{
+		strings.Replace(string(data), "+12|0+", "+12+", 1),
+		strings.Replace(string(data), "+5|6+", "+5|x+", 1),
+	}
+
+	for _, c := range mismatchCases {
+		status, err := ParseStatus(c)
+
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected: %v", err)
+			continue
+		}
+
+		if status.Errors != (LineErrors{}) {
+			t.Errorf("Expected the errors to be zeroed: got %v", status.Errors)
+		}
+
+		if status.ModemUptime != time.Duration(5000)*time.Second || len(status.LineRates) != 2 {
+			t.Errorf("Invalid status: got %+v", status)
+		}
+	}
+
+	errorCases := []string{
+		"",
+		"0+1+2",
+		strings.Replace(string(data), "+5000+", "+x+", 1),
+	}
+
+	for _, c := range errorCases {
+		_, err := ParseStatus(c)
+
+		if err == nil {
+			t.Errorf("Expected an error; got none")
+		}
+	}
+}
+
+func TestStringToNearFarPair(t *testing.T) {
+	successCases := []struct {
+		input string
+		pair  NearFarPair
+	}{
+		{
+			"0|0",
+			NearFarPair{0, 0},
+		},
+		{
+			"1|2",
+			NearFarPair{1, 2},
+		},
+	}
+
+	for _, c := range successCases {
+		pair, err := stringToNearFarPair(c.input)
+
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected")
+		}
+
+		if c.pair != pair {
+			t.Errorf("Invalid pair: got %v; expected %v", pair, c.pair)
+		}
+	}
+
+	errorCases := []string{
+		"",
+		"1|",
+		"a|b",
+		"1|2|3",
+		"1/2",
+	}
+
+	for _, c := range errorCases {
+		_, err := stringToNearFarPair(c)
+
+		if err == nil {
+			t.Errorf("Expected an error; got none")
+		}
+	}
+}
+
+func TestStringToPathCounters(t *testing.T) {
+	successCases := []struct {
+		input    string
+		counters PathCounters
+	}{
+		{
+			"0|0",
+			PathCounters{0, 0},
+		},
+		{
+			"100|2",
+			PathCounters{100, 2},
+		},
+	}
+
+	for _, c := range successCases {
+		counters, err := stringToPathCounters(c.input)
+
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected")
+		}
+
+		if c.counters != counters {
+			t.Errorf("Invalid counters: got %v; expected %v", counters, c.counters)
+		}
+	}
+
+	errorCases := []string{
+		"",
+		"|",
+		"0|",
+		"-1|0",
+		"1|2|3",
+	}
+
+	for _, c := range errorCases {
+		_, err := stringToPathCounters(c)
+
+		if err == nil {
+			t.Errorf("Expected an error; got none")
+		}
+	}
+}
+
+func TestStatusIsForLine(t *testing.T) {
+	status := &Status{
+		LineStats: LineStats{State: Up, Rates: Rates{1000, 5000}},
+		LineRates: []LineRate{
+			{Rates{2000, 6000}, Up},
+			{Rates{1000, 5000}, Up},
+		},
+	}
+
+	cases := []struct {
+		line     int
+		expected bool
+	}{
+		{-1, false},
+		{0, false},
+		{1, true},
+		{2, false},
+	}
+
+	for _, c := range cases {
+		if actual := status.IsForLine(c.line); actual != c.expected {
+			t.Errorf("Line %d: got %v; expected %v", c.line, actual, c.expected)
+		}
+	}
+
+	if !(&Status{}).IsForLine(0) {
+		t.Errorf("Expected a status without line rates to match line 0")
+	}
+}
@@ -0,0 +1,445 @@
+package alert
+
+// Threshold rules on line metrics, evaluated against every sample as it's
+// collected, so that a line going bad gets noticed when it happens rather than
+// the next time somebody looks at a dashboard.
+//
+// Rules work on the same flattened field names as the history (see
+// history.Values), so LinkUp is 1 while a line is up. A rule on a field that
+// each line has is evaluated for each line separately; anything else is
+// evaluated once for the modem.
+//
+// To keep a value that's hovering around the threshold from firing and
+// resolving over and over, a condition has to hold for a while (For) before
+// the alert fires, and once it has, the value has to come back past the
+// threshold by some margin (Hysteresis) before it resolves.
+//
+// An alert also resolves if what it's about stops reporting: straight away
+// for a line (or field) missing from its router's latest sample, or once a
+// router hasn't been heard from for a while (see Engine.SetStale). Those
+// alerts are marked Stale, since nobody knows what the value is now.
+
+import (
+	"fmt"
+	"history"
+	"log"
+	"sink"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Op int
+
+const (
+	Below Op = iota
+	Above
+)
+
+func (op Op) String() string {
+	switch op {
+	case Below:
+		return "<"
+	case Above:
+		return ">"
+	}
+
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+type Rule struct {
+	// Identifies the rule in alerts, so should be short and unique.
+	Name string
+
+	Field     string
+	Op        Op
+	Threshold float64
+
+	// How far back past the threshold the value has to go for a firing alert
+	// to resolve.
+	Hysteresis float64
+
+	// How long the condition has to hold before the alert fires.
+	For time.Duration
+
+	// If set, the rule applies to how much the field has changed over this
+	// period (or as much of it as there are samples for), rather than to the
+	// field itself. This is what makes counters like Retrains useful.
+	Over time.Duration
+}
+
+func (r *Rule) matches(v float64) bool {
+	if r.Op == Above {
+		return v > r.Threshold
+	}
+	return v < r.Threshold
+}
+
+func (r *Rule) resolved(v float64) bool {
+	if r.Op == Above {
+		return v <= r.Threshold-r.Hysteresis
+	}
+	return v >= r.Threshold+r.Hysteresis
+}
+
+// A description of the condition, like "< 6" or "change over 1h0m0s > 5".
+func (r *Rule) Condition() string {
+	condition := fmt.Sprintf("%v %g", r.Op, r.Threshold)
+	if r.Over > 0 {
+		condition = fmt.Sprintf("change over %v %s", r.Over, condition)
+	}
+
+	return condition
+}
+
+// An alert firing or resolving. Line is -1 for rules on the modem as a whole.
+type Alert struct {
+	sink.Source
+	Rule      string
+	Field     string
+	Line      int
+	Condition string
+	Value     float64
+	Firing    bool
+
+	// The line or router stopped reporting, so Value is the last one seen.
+	// Only ever set on resolved alerts.
+	Stale bool
+
+	// When the condition started to hold, and when the alert fired or
+	// resolved.
+	Since time.Time
+	Time  time.Time
+}
+
+func (a *Alert) State() string {
+	if a.Firing {
+		return "firing"
+	}
+	return "resolved"
+}
+
+// A one line description, suitable for a log or an email subject.
+func (a *Alert) Summary() string {
+	where := a.Router
+	if a.Line >= 0 {
+		where = fmt.Sprintf("%s line %d", a.Router, a.Line)
+	}
+
+	if a.Stale {
+		return fmt.Sprintf("[%s] %s: %s on %s stopped reporting (was %g, %s)", strings.ToUpper(a.State()), a.Rule, a.Field, where, a.Value, a.Condition)
+	}
+	return fmt.Sprintf("[%s] %s: %s on %s is %g (%s)", strings.ToUpper(a.State()), a.Rule, a.Field, where, a.Value, a.Condition)
+}
+
+// Where alerts go when they fire or resolve. Notify may be called from any
+// collection loop, so it must be safe for concurrent use.
+type Notifier interface {
+	Notify(a *Alert) error
+}
+
+// Evaluates the rules against every sample it's sent, and tells the notifiers
+// when an alert fires or resolves. Alerts are logged too. It's a sink, so that
+// it can be fed the same way as everything else. Events aren't evaluated, but
+// they do prompt a check for routers that have gone quiet (see SetStale).
+//
+// All the state is in memory, so a new engine (after a restart, or reloading
+// the configuration) knows nothing about what the old one had firing. Anything
+// that's still a problem fires again, but nothing resolves what it replaced.
+type Engine struct {
+	rules     []Rule
+	notifiers []Notifier
+
+	// The longest Over of any rule: how much of each router's recent history
+	// needs keeping.
+	window time.Duration
+
+	// How long a router can go without a sample before its alerts are
+	// resolved as stale. Zero means never.
+	stale time.Duration
+
+	mu      sync.Mutex
+	recent  map[string][]history.Point
+	pending map[key]*state
+}
+
+// What an alert is about.
+type key struct {
+	rule   string
+	router string
+	line   int
+}
+
+type state struct {
+	since  time.Time
+	firing bool
+
+	// When the alert was last evaluated, and the value and source then.
+	seen   time.Time
+	value  float64
+	source sink.Source
+}
+
+func NewEngine(rules []Rule, notifiers ...Notifier) *Engine {
+	e := &Engine{
+		rules:     rules,
+		notifiers: notifiers,
+		recent:    make(map[string][]history.Point),
+		pending:   make(map[key]*state),
+	}
+
+	for _, r := range rules {
+		if r.Over > e.window {
+			e.window = r.Over
+		}
+	}
+
+	return e
+}
+
+// Set how long a router can go without a sample before its alerts are
+// resolved as stale. This should be a few times the router's collection
+// interval, so that a slow collection or two doesn't resolve everything.
+//
+// The engine doesn't keep time itself: routers are checked whenever it's sent
+// a sample or an event. When collecting continuously, the health events sent
+// every interval (and RouterUnreachable, when a router can't be reached) see
+// to that, even if the only router there is has stopped reporting.
+func (e *Engine) SetStale(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stale = d
+}
+
+func (e *Engine) Send(sample *sink.Sample) error {
+	return e.notify(e.evaluate(sample))
+}
+
+func (e *Engine) SendEvent(event *sink.Event) error {
+	now := event.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	e.mu.Lock()
+	alerts := e.expireQuiet(now)
+	e.mu.Unlock()
+
+	return e.notify(alerts)
+}
+
+// Logs the alerts, and hands them to every notifier.
+func (e *Engine) notify(alerts []*Alert) error {
+	var errs []string
+	for _, a := range alerts {
+		log.Print(a.Summary())
+
+		for _, n := range e.notifiers {
+			if err := n.Notify(a); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Error sending alerts: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Works out which alerts fire or resolve with this sample.
+func (e *Engine) evaluate(sample *sink.Sample) []*Alert {
+	p := history.NewPoint(sample)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	recent := append(e.recent[sample.Router], p)
+	for len(recent) > 1 && recent[0].Time.Before(p.Time.Add(-e.window)) {
+		recent = recent[1:]
+	}
+	e.recent[sample.Router] = recent
+
+	var alerts []*Alert
+	evaluated := make(map[key]bool)
+	for i := range e.rules {
+		r := &e.rules[i]
+
+		// Per line if the field is a line field, otherwise for the modem.
+		lines := []int{-1}
+		if len(p.Lines) > 0 {
+			if _, ok := p.Lines[0][r.Field]; ok {
+				lines = lines[:0]
+				for line := range p.Lines {
+					lines = append(lines, line)
+				}
+			}
+		}
+
+		for _, line := range lines {
+			v, ok := e.value(r, recent, line)
+			if !ok {
+				continue
+			}
+
+			k := key{r.Name, sample.Router, line}
+			evaluated[k] = true
+			if a := e.transition(r, k, v, p.Time); a != nil {
+				a.Source = sample.Source
+				alerts = append(alerts, a)
+			}
+			if s, ok := e.pending[k]; ok {
+				s.source = sample.Source
+			}
+		}
+	}
+
+	alerts = append(alerts, e.expireMissing(sample, evaluated)...)
+	return append(alerts, e.expireQuiet(p.Time)...)
+}
+
+// Resolves alerts for lines and fields this router's sample doesn't have any
+// more. Alerts that hadn't fired yet are just forgotten.
+//
+// Must be called with the lock held.
+func (e *Engine) expireMissing(sample *sink.Sample, evaluated map[key]bool) []*Alert {
+	var alerts []*Alert
+	for k, s := range e.pending {
+		if k.router == sample.Router && !evaluated[k] {
+			alerts = append(alerts, e.expireKey(k, s, sample.Time)...)
+		}
+	}
+
+	return alerts
+}
+
+// Resolves alerts for routers that haven't sent a sample for longer than the
+// stale period, as of now.
+//
+// Must be called with the lock held.
+func (e *Engine) expireQuiet(now time.Time) []*Alert {
+	if e.stale == 0 {
+		return nil
+	}
+
+	var alerts []*Alert
+	for k, s := range e.pending {
+		if now.Sub(s.seen) > e.stale {
+			alerts = append(alerts, e.expireKey(k, s, now)...)
+		}
+	}
+
+	// Nor is there any point keeping history for routers that have gone
+	// quiet.
+	for router, recent := range e.recent {
+		if now.Sub(recent[len(recent)-1].Time) > e.stale {
+			delete(e.recent, router)
+		}
+	}
+
+	return alerts
+}
+
+// Forgets about an alert whose subject has stopped reporting, returning a
+// stale resolution if it had fired.
+//
+// Must be called with the lock held.
+func (e *Engine) expireKey(k key, s *state, now time.Time) []*Alert {
+	delete(e.pending, k)
+	if !s.firing {
+		return nil
+	}
+
+	var alerts []*Alert
+	for i := range e.rules {
+		if r := &e.rules[i]; r.Name == k.rule {
+			a := e.alert(r, k, s.value, s, false, now)
+			a.Stale = true
+			a.Source = s.source
+			alerts = append(alerts, a)
+		}
+	}
+
+	return alerts
+}
+
+// The value the rule applies to, for a line (or the modem, if line is -1):
+// the latest value of the field, or how much it's changed over the rule's
+// period.
+func (e *Engine) value(r *Rule, recent []history.Point, line int) (float64, bool) {
+	latest := recent[len(recent)-1]
+	v, ok := lookup(latest, r.Field, line)
+	if !ok || r.Over == 0 {
+		return v, ok
+	}
+
+	cutoff := latest.Time.Add(-r.Over)
+	for _, p := range recent {
+		if p.Time.Before(cutoff) {
+			continue
+		}
+		if base, ok := lookup(p, r.Field, line); ok {
+			return v - base, true
+		}
+	}
+
+	return 0, false
+}
+
+func lookup(p history.Point, field string, line int) (float64, bool) {
+	values := p.Status
+	if line >= 0 {
+		if line >= len(p.Lines) {
+			return 0, false
+		}
+		values = p.Lines[line]
+	}
+
+	v, ok := values[field]
+	return v, ok
+}
+
+// Moves an alert along, returning it if it's just fired or resolved.
+//
+// Must be called with the lock held.
+func (e *Engine) transition(r *Rule, k key, v float64, now time.Time) *Alert {
+	s, ok := e.pending[k]
+	if ok {
+		s.seen, s.value = now, v
+	}
+
+	switch {
+	case !ok && r.matches(v):
+		s = &state{since: now, seen: now, value: v}
+		e.pending[k] = s
+	case !ok:
+		return nil
+	case !s.firing && !r.matches(v):
+		delete(e.pending, k)
+		return nil
+	case s.firing && r.resolved(v):
+		delete(e.pending, k)
+		return e.alert(r, k, v, s, false, now)
+	}
+
+	if !s.firing && now.Sub(s.since) >= r.For {
+		s.firing = true
+		return e.alert(r, k, v, s, true, now)
+	}
+
+	return nil
+}
+
+func (e *Engine) alert(r *Rule, k key, v float64, s *state, firing bool, now time.Time) *Alert {
+	return &Alert{
+		Rule:      r.Name,
+		Field:     r.Field,
+		Line:      k.line,
+		Condition: r.Condition(),
+		Value:     v,
+		Firing:    firing,
+		Since:     s.since,
+		Time:      now,
+	}
+}
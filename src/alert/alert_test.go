@@ -0,0 +1,403 @@
+package alert
+
+import (
+	"actiontec"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sink"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var start = time.Unix(1500000000, 0)
+
+type recorder struct {
+	mu     sync.Mutex
+	alerts []*Alert
+}
+
+func (r *recorder) Notify(a *Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+// A sample from a single line modem, at minute i.
+func sample(i int, snr uint64, state actiontec.State, retrains uint64) *sink.Sample {
+	return &sink.Sample{
+		Source: sink.Source{Router: "home", Host: "192.168.0.1"},
+		Time:   start.Add(time.Duration(i) * time.Minute),
+		Status: &actiontec.Status{TotalRetrains: retrains},
+		Lines: []actiontec.LineStats{
+			{State: state, SignalNoiseMargin: actiontec.UintPair{Up: 10, Down: snr}, Retrains: retrains},
+		},
+	}
+}
+
+func TestEngine(t *testing.T) {
+	successCases := []struct {
+		rule     Rule
+		samples  []*sink.Sample
+		expected []string // State and minute of each alert
+	}{
+		// Fires straight away, and resolves as soon as it's back.
+		{
+			Rule{Name: "snr", Field: "SignalNoiseMarginDown", Op: Below, Threshold: 6},
+			[]*sink.Sample{
+				sample(0, 8, actiontec.Up, 0),
+				sample(1, 5, actiontec.Up, 0),
+				sample(2, 4, actiontec.Up, 0),
+				sample(3, 6, actiontec.Up, 0),
+				sample(4, 5, actiontec.Up, 0),
+			},
+			[]string{"firing 1", "resolved 3", "firing 4"},
+		},
+		// Has to be low for two minutes, and come back up to 7 to resolve.
+		{
+			Rule{Name: "snr", Field: "SignalNoiseMarginDown", Op: Below, Threshold: 6, Hysteresis: 1, For: 2 * time.Minute},
+			[]*sink.Sample{
+				sample(0, 5, actiontec.Up, 0),
+				sample(1, 5, actiontec.Up, 0),
+				sample(2, 8, actiontec.Up, 0),
+				sample(3, 5, actiontec.Up, 0),
+				sample(4, 5, actiontec.Up, 0),
+				sample(5, 5, actiontec.Up, 0),
+				sample(6, 6, actiontec.Up, 0),
+				sample(7, 5, actiontec.Up, 0),
+				sample(8, 7, actiontec.Up, 0),
+			},
+			[]string{"firing 5", "resolved 8"},
+		},
+		{
+			Rule{Name: "down", Field: "LinkUp", Op: Below, Threshold: 1},
+			[]*sink.Sample{
+				sample(0, 8, actiontec.Up, 0),
+				sample(1, 0, actiontec.Down, 0),
+				sample(2, 0, actiontec.EstablishingLink, 0),
+				sample(3, 8, actiontec.Up, 1),
+			},
+			[]string{"firing 1", "resolved 3"},
+		},
+		// More than 2 retrains in any 10 minutes.
+		{
+			Rule{Name: "retrains", Field: "Retrains", Op: Above, Threshold: 2, Over: 10 * time.Minute},
+			[]*sink.Sample{
+				sample(0, 8, actiontec.Up, 5),
+				sample(5, 8, actiontec.Up, 7),
+				sample(10, 8, actiontec.Up, 8),
+				sample(15, 8, actiontec.Up, 9),
+				sample(20, 8, actiontec.Up, 9),
+				sample(25, 8, actiontec.Up, 9),
+			},
+			[]string{"firing 10", "resolved 15"},
+		},
+	}
+
+	for _, c := range successCases {
+		r := new(recorder)
+		e := NewEngine([]Rule{c.rule}, r)
+
+		for _, s := range c.samples {
+			if err := e.Send(s); err != nil {
+				t.Errorf("Got an error when one wasn't expected")
+			}
+		}
+
+		var actual []string
+		for _, a := range r.alerts {
+			actual = append(actual, fmt.Sprintf("%s %d", a.State(), int(a.Time.Sub(start).Minutes())))
+		}
+
+		if strings.Join(actual, ", ") != strings.Join(c.expected, ", ") {
+			t.Errorf("%s: expected alerts %v; got %v", c.rule.Name, c.expected, actual)
+		}
+	}
+}
+
+func TestAlert(t *testing.T) {
+	r := new(recorder)
+	e := NewEngine([]Rule{
+		{Name: "snr", Field: "SignalNoiseMarginDown", Op: Below, Threshold: 6},
+		{Name: "retrains", Field: "Retrains", Op: Above, Threshold: 2, Over: time.Hour},
+	}, r)
+
+	e.Send(sample(0, 8, actiontec.Up, 0))
+	e.Send(sample(1, 5, actiontec.Up, 3))
+
+	if len(r.alerts) != 2 {
+		t.Fatalf("Expected 2 alerts; got %d", len(r.alerts))
+	}
+
+	a := r.alerts[0]
+	if a.Router != "home" || a.Line != 0 || a.Value != 5 || !a.Firing || !a.Since.Equal(start.Add(time.Minute)) {
+		t.Errorf("Invalid alert: %+v", a)
+	}
+	if expected := "[FIRING] snr: SignalNoiseMarginDown on home line 0 is 5 (< 6)"; a.Summary() != expected {
+		t.Errorf("Expected summary %q; got %q", expected, a.Summary())
+	}
+	if expected := "change over 1h0m0s > 2"; r.alerts[1].Condition != expected {
+		t.Errorf("Expected condition %q; got %q", expected, r.alerts[1].Condition)
+	}
+}
+
+func TestStale(t *testing.T) {
+	r := new(recorder)
+	e := NewEngine([]Rule{{Name: "snr", Field: "SignalNoiseMarginDown", Op: Below, Threshold: 6}}, r)
+	e.SetStale(5 * time.Minute)
+
+	office := func(i int) *sink.Sample {
+		s := sample(i, 8, actiontec.Up, 0)
+		s.Router = "office"
+		return s
+	}
+
+	// Both lines of home go bad, then line 1 disappears.
+	two := sample(0, 5, actiontec.Up, 0)
+	two.Lines = append(two.Lines, two.Lines[0])
+	e.Send(two)
+	e.Send(sample(1, 5, actiontec.Up, 0))
+
+	if len(r.alerts) != 3 {
+		t.Fatalf("Expected 3 alerts; got %d", len(r.alerts))
+	}
+	if a := r.alerts[2]; a.Firing || !a.Stale || a.Line != 1 || a.Value != 5 || a.Router != "home" {
+		t.Errorf("Expected line 1 to resolve as stale: %+v", a)
+	}
+
+	// Then home goes quiet while office carries on. Line 0 only resolves once
+	// home's been quiet for longer than the stale period.
+	e.Send(office(5))
+	if len(r.alerts) != 3 {
+		t.Errorf("Didn't expect an alert yet: %+v", r.alerts[3:])
+	}
+	e.Send(office(7))
+	if len(r.alerts) != 4 {
+		t.Fatalf("Expected 4 alerts; got %d", len(r.alerts))
+	}
+	if a := r.alerts[3]; a.Firing || !a.Stale || a.Line != 0 || a.Router != "home" {
+		t.Errorf("Expected line 0 to resolve as stale: %+v", a)
+	}
+	if expected := "[RESOLVED] snr: SignalNoiseMarginDown on home line 0 stopped reporting (was 5, < 6)"; r.alerts[3].Summary() != expected {
+		t.Errorf("Expected summary %q; got %q", expected, r.alerts[3].Summary())
+	}
+
+	if len(e.pending) != 0 {
+		t.Errorf("Expected nothing to be pending: %v", e.pending)
+	}
+}
+
+// With only one router, there are no other samples to notice it's gone quiet,
+// so events have to.
+func TestStaleEvent(t *testing.T) {
+	r := new(recorder)
+	e := NewEngine([]Rule{{Name: "snr", Field: "SignalNoiseMarginDown", Op: Below, Threshold: 6}}, r)
+	e.SetStale(5 * time.Minute)
+
+	e.Send(sample(0, 5, actiontec.Up, 0))
+	if len(r.alerts) != 1 {
+		t.Fatalf("Expected 1 alert; got %d", len(r.alerts))
+	}
+
+	unreachable := func(i int) *sink.Event {
+		return &sink.Event{
+			Source: sink.Source{Router: "home"},
+			Time:   start.Add(time.Duration(i) * time.Minute),
+			Type:   "RouterUnreachable",
+		}
+	}
+
+	e.SendEvent(unreachable(3))
+	if len(r.alerts) != 1 {
+		t.Errorf("Didn't expect an alert yet: %+v", r.alerts[1:])
+	}
+
+	e.SendEvent(unreachable(6))
+	if len(r.alerts) != 2 {
+		t.Fatalf("Expected 2 alerts; got %d", len(r.alerts))
+	}
+	if a := r.alerts[1]; a.Firing || !a.Stale || a.Router != "home" || !a.Time.Equal(start.Add(6*time.Minute)) {
+		t.Errorf("Expected the alert to resolve as stale: %+v", a)
+	}
+
+	if len(e.pending) != 0 || len(e.recent) != 0 {
+		t.Errorf("Expected everything to be forgotten: %v, %v", e.pending, e.recent)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Got an error when one wasn't expected")
+		}
+	}))
+	defer server.Close()
+
+	a := &Alert{
+		Source:    sink.Source{Router: "home"},
+		Rule:      "down",
+		Field:     "LinkUp",
+		Line:      1,
+		Condition: "< 1",
+		Firing:    true,
+		Since:     start,
+		Time:      start,
+	}
+	if err := NewWebhook(server.URL, time.Second).Notify(a); err != nil {
+		t.Errorf("Got an error when one wasn't expected")
+	}
+
+	for k, v := range map[string]interface{}{
+		"state":  "firing",
+		"rule":   "down",
+		"router": "home",
+		"line":   1.0,
+		"value":  0.0,
+	} {
+		if received[k] != v {
+			t.Errorf("Expected %s to be %v; got %v", k, v, received[k])
+		}
+	}
+
+	// Modem wide alerts don't have a line.
+	a.Line = -1
+	NewWebhook(server.URL, time.Second).Notify(a)
+	if _, ok := received["line"]; ok {
+		t.Errorf("Didn't expect a line: %v", received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	if err := NewWebhook(failing.URL, time.Second).Notify(a); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
+
+type eventSink struct {
+	events []*sink.Event
+}
+
+func (s *eventSink) Send(sample *sink.Sample) error { return nil }
+
+func (s *eventSink) SendEvent(event *sink.Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	s := new(eventSink)
+	n := &Sinks{Sinks: s}
+
+	n.Notify(&Alert{Source: sink.Source{Router: "home"}, Rule: "snr", Line: 0, Value: 5, Time: start})
+
+	if len(s.events) != 1 {
+		t.Fatalf("Expected an event; got %d", len(s.events))
+	}
+
+	e := s.events[0]
+	if e.Type != "Alert" || e.Router != "home" || e.Attributes["State"] != "resolved" || e.Attributes["Line"] != 0 {
+		t.Errorf("Invalid event: %+v", e)
+	}
+}
+
+func TestSMTPMessage(t *testing.T) {
+	s := &SMTP{From: "modem@example.com", To: []string{"a@example.com", "b@example.com"}}
+	message := string(s.message(&Alert{
+		Source: sink.Source{Router: "home", Host: "192.168.0.1"},
+		Rule:   "down",
+		Field:  "LinkUp",
+		Line:   -1,
+		Firing: true,
+		Time:   start,
+	}))
+
+	for _, expected := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: [FIRING] down: LinkUp on home is 0 ()\r\n",
+		"\r\n\r\nRule:      down\r\n",
+		"Router:    home (192.168.0.1)\r\n",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected message to contain %q; got %q", expected, message)
+		}
+	}
+	if strings.Contains(message, "Line:") {
+		t.Errorf("Didn't expect a line: %q", message)
+	}
+}
+
+// Just enough of an SMTP server to take one message, which it hands back.
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan string, 1)
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "220 localhost\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "DATA":
+				fmt.Fprintf(conn, "354 go on\r\n")
+				message := new(strings.Builder)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					message.WriteString(line)
+				}
+				messages <- message.String()
+				fmt.Fprintf(conn, "250 ok\r\n")
+			case "QUIT":
+				fmt.Fprintf(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprintf(conn, "250 ok\r\n")
+			}
+		}
+	}()
+
+	return l.Addr().String(), messages
+}
+
+func TestSMTP(t *testing.T) {
+	addr, messages := fakeSMTPServer(t)
+
+	// No timeout is no timeout, not an immediate one.
+	s := &SMTP{Addr: addr, From: "modem@example.com", To: []string{"me@example.com"}}
+	if err := s.Notify(&Alert{Source: sink.Source{Router: "home"}, Rule: "down", Line: -1, Time: start}); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if message := <-messages; !strings.Contains(message, "Rule:      down") {
+		t.Errorf("Unexpected message: %q", message)
+	}
+}
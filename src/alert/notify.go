@@ -0,0 +1,212 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sink"
+	"strings"
+	"time"
+)
+
+// POSTs each alert as JSON to a URL, for chat webhooks, pagers, or anything
+// else that can be glued to one.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// What gets POSTed. The field names are part of the interface, so they
+// mustn't change.
+type webhookPayload struct {
+	Summary   string            `json:"summary"`
+	State     string            `json:"state"`
+	Rule      string            `json:"rule"`
+	Router    string            `json:"router"`
+	Host      string            `json:"host"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Field     string            `json:"field"`
+	Line      *int              `json:"line,omitempty"`
+	Condition string            `json:"condition"`
+	Value     float64           `json:"value"`
+	Stale     bool              `json:"stale,omitempty"`
+	Since     time.Time         `json:"since"`
+	Time      time.Time         `json:"time"`
+}
+
+func (w *Webhook) Notify(a *Alert) error {
+	payload := webhookPayload{
+		Summary:   a.Summary(),
+		State:     a.State(),
+		Rule:      a.Rule,
+		Router:    a.Router,
+		Host:      a.Host,
+		Tags:      a.Tags,
+		Field:     a.Field,
+		Condition: a.Condition,
+		Value:     a.Value,
+		Stale:     a.Stale,
+		Since:     a.Since,
+		Time:      a.Time,
+	}
+	if a.Line >= 0 {
+		line := a.Line
+		payload.Line = &line
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Unexpected HTTP response code from alert webhook: %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+// Emails each alert. STARTTLS is used if the server offers it, and if there's
+// a username, authentication is too (which net/smtp won't do without TLS,
+// unless the server's on localhost).
+//
+// Timeout bounds the whole conversation with the server. Zero means no
+// timeout.
+type SMTP struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+func (s *SMTP) Notify(a *Alert) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	// net/smtp doesn't do timeouts itself, and we'd rather not hang a
+	// collection loop on a mail server that's gone away.
+	conn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+	if err != nil {
+		return err
+	}
+	if s.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(a)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (s *SMTP) message(a *Alert) []byte {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "From: %s\r\n", s.From)
+	fmt.Fprintf(b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(b, "Subject: %s\r\n", a.Summary())
+	fmt.Fprintf(b, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(b, "\r\n")
+
+	fmt.Fprintf(b, "Rule:      %s\r\n", a.Rule)
+	if a.Stale {
+		fmt.Fprintf(b, "State:     %s (stopped reporting)\r\n", a.State())
+	} else {
+		fmt.Fprintf(b, "State:     %s\r\n", a.State())
+	}
+	fmt.Fprintf(b, "Router:    %s (%s)\r\n", a.Router, a.Host)
+	if a.Line >= 0 {
+		fmt.Fprintf(b, "Line:      %d\r\n", a.Line)
+	}
+	fmt.Fprintf(b, "Field:     %s\r\n", a.Field)
+	fmt.Fprintf(b, "Value:     %g\r\n", a.Value)
+	fmt.Fprintf(b, "Condition: %s\r\n", a.Condition)
+	fmt.Fprintf(b, "Since:     %s\r\n", a.Since.Format(time.RFC1123Z))
+
+	return b.Bytes()
+}
+
+// Sends each alert on to some sinks as an "Alert" event, so that alerts end
+// up alongside everything else (in Insights or InfluxDB, say).
+type Sinks struct {
+	Sinks sink.Sink
+}
+
+func (s *Sinks) Notify(a *Alert) error {
+	attributes := map[string]interface{}{
+		"Rule":      a.Rule,
+		"State":     a.State(),
+		"Field":     a.Field,
+		"Condition": a.Condition,
+		"Value":     a.Value,
+		"Since":     a.Since.Unix(),
+	}
+	if a.Line >= 0 {
+		attributes["Line"] = a.Line
+	}
+	if a.Stale {
+		attributes["Stale"] = true
+	}
+
+	return s.Sinks.SendEvent(&sink.Event{
+		Source:     a.Source,
+		Time:       a.Time,
+		Type:       "Alert",
+		Attributes: attributes,
+	})
+}
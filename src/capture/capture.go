@@ -0,0 +1,109 @@
+package capture
+
+// Captures of raw refresh payloads, so that parser bugs can be reproduced from
+// someone else's firmware, and so that historical data can be pushed into a
+// new sink after the fact.
+//
+// A capture file is just JSON, one record per line, in the order the payloads
+// were received from the router. Each record also says which router it came
+// from, so that captures from several modems can be replayed side by side, and
+// which parser profile it was collected with, if one was set explicitly, so
+// that it's parsed the same way when it's replayed.
+
+import (
+	"actiontec"
+	"encoding/json"
+	"io"
+	"sink"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Record struct {
+	Time     time.Time         `json:"time"`
+	Router   string            `json:"router,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Firmware string            `json:"firmware"`
+	Profile  string            `json:"profile,omitempty"`
+	Line     int               `json:"line"`
+	Payload  string            `json:"payload"`
+}
+
+// Writes records to an underlying writer. Implements actiontec.Recorder, so it
+// can be handed straight to Context.SetRecorder.
+type Writer struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	source  sink.Source
+	profile string
+}
+
+// The source is written into every record, so replayed samples end up
+// attributed to the router they were captured from.
+func NewWriter(w io.Writer, source sink.Source) *Writer {
+	return &Writer{enc: json.NewEncoder(w), source: source}
+}
+
+// Set the profile the payloads are being parsed with, which should be whatever
+// was passed to Context.SetProfile. Pass nil if the profile is being picked
+// based on the firmware.
+func (w *Writer) SetProfile(p *actiontec.Profile) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.profile = ""
+	if p != nil {
+		w.profile = p.Name
+	}
+}
+
+func (w *Writer) Record(line int, payload string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.enc.Encode(&Record{
+		Time:     time.Now(),
+		Router:   w.source.Router,
+		Host:     w.source.Host,
+		Tags:     w.source.Tags,
+		Firmware: firmware(payload),
+		Profile:  w.profile,
+		Line:     line,
+		Payload:  payload,
+	})
+}
+
+type Reader struct {
+	dec *json.Decoder
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Returns the next record, or io.EOF if there are no more.
+func (r *Reader) Next() (*Record, error) {
+	record := new(Record)
+	if err := r.dec.Decode(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// The firmware version is the third field in the payload. We don't want to
+// rely on ParseStatus here, since the whole point of capturing is to be able
+// to look at payloads that it can't handle.
+func firmware(payload string) string {
+	fields := strings.SplitN(payload, "+", 5)
+	if len(fields) < 4 {
+		return ""
+	}
+
+	return fields[3]
+}
+
+// Make sure Writer keeps up with the interface.
+var _ actiontec.Recorder = (*Writer)(nil)
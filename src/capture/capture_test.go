@@ -0,0 +1,172 @@
+package capture
+
+import (
+	"actiontec"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sink"
+	"strings"
+	"testing"
+)
+
+func loadFixture(t *testing.T) string {
+	data, err := ioutil.ReadFile("../actiontec/testdata/T2200H-synthetic.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestRoundTrip(t *testing.T) {
+	payload := loadFixture(t)
+	buffer := new(bytes.Buffer)
+	w := NewWriter(buffer, sink.Source{Router: "home", Host: "192.168.0.1"})
+
+	// Two good samples of two lines each, with a bad sample between them.
+	for _, r := range []struct {
+		line    int
+		payload string
+	}{
+		{0, payload},
+		{1, payload},
+		{0, "garbage"},
+		{0, payload},
+		{1, payload},
+	} {
+		if err := w.Record(r.line, r.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replayer := NewReplayer(bytes.NewReader(buffer.Bytes()))
+
+	sample, err := replayer.Next()
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+	if len(sample.Lines) != 2 || sample.Status.SoftwareVersion != "T2200H-31.128L.03" || sample.Router != "home" || sample.Host != "192.168.0.1" {
+		t.Errorf("Unexpected sample: %v", sample)
+	}
+
+	if _, err := replayer.Next(); err == nil {
+		t.Errorf("Expected an error; got none")
+	} else if _, ok := err.(*ParseError); !ok {
+		t.Errorf("Expected a parse error; got %v", err)
+	}
+
+	sample, err = replayer.Next()
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+	if len(sample.Lines) != 2 {
+		t.Errorf("Unexpected number of lines: %d", len(sample.Lines))
+	}
+
+	if _, err := replayer.Next(); err != io.EOF {
+		t.Errorf("Expected EOF; got %v", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	payload := loadFixture(t)
+	buffer := new(bytes.Buffer)
+	w := NewWriter(buffer, sink.Source{Router: "home"})
+
+	w.SetProfile(actiontec.T2200H)
+	w.Record(0, payload)
+
+	record, err := NewReader(bytes.NewReader(buffer.Bytes())).Next()
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+	if record.Profile != "T2200H" {
+		t.Errorf("Expected the profile to be recorded; got %q", record.Profile)
+	}
+
+	r := NewReplayer(strings.NewReader(""))
+	if _, err := r.add(record); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+
+	// A profile that's gone away since the capture was made can't be guessed
+	// at.
+	record.Profile = "V9999"
+	if _, err := r.add(record); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
+
+func TestReplayRouters(t *testing.T) {
+	payload := loadFixture(t)
+
+	// The fixture's lines have the same rates, so this is line 0's stats with
+	// line 1 looking different, which makes it wrong for line 1.
+	wrongLine := strings.TrimSuffix(payload, "Up|10000|50000+") + "Up|20000|60000+"
+	if wrongLine == payload {
+		t.Fatal("Couldn't make a payload for the wrong line")
+	}
+
+	buffer := new(bytes.Buffer)
+	home := NewWriter(buffer, sink.Source{Router: "home"})
+	office := NewWriter(buffer, sink.Source{Router: "office"})
+
+	// Two collectors writing to one file, interleaved.
+	home.Record(0, payload)
+	office.Record(0, payload)
+	office.Record(1, payload)
+	home.Record(1, payload)
+
+	// A collection that gave up after line 0, followed by one that didn't.
+	home.Record(0, payload)
+	home.Record(0, payload)
+	home.Record(1, payload)
+
+	// The router handed back the wrong line, so this was never sent either.
+	office.Record(0, payload)
+	office.Record(1, wrongLine)
+
+	// And one that was still going when the capture stopped.
+	home.Record(0, payload)
+
+	replayer := NewReplayer(bytes.NewReader(buffer.Bytes()))
+
+	for _, expected := range []string{"office", "home", "home"} {
+		sample, err := replayer.Next()
+		if err != nil {
+			t.Fatalf("Got an error when one wasn't expected: %v", err)
+		}
+		if sample.Router != expected || len(sample.Lines) != 2 {
+			t.Errorf("Expected two lines from %s; got %d from %s", expected, len(sample.Lines), sample.Router)
+		}
+	}
+
+	if _, err := replayer.Next(); err == nil {
+		t.Errorf("Expected an error; got none")
+	} else if perr, ok := err.(*ParseError); !ok || perr.Record.Router != "office" || perr.Record.Line != 1 {
+		t.Errorf("Expected a parse error for office line 1; got %v", err)
+	}
+
+	if _, err := replayer.Next(); err != io.EOF {
+		t.Errorf("Expected EOF; got %v", err)
+	}
+}
+
+func TestFirmware(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"0+1+2", ""},
+		{"0+1+2+T2200H-31.128L.03", "T2200H-31.128L.03"},
+		{loadFixture(t), "T2200H-31.128L.03"},
+	}
+
+	for _, c := range cases {
+		if f := firmware(c.input); f != c.expected {
+			t.Errorf("Invalid firmware: got %q; expected %q", f, c.expected)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"actiontec"
+	"fmt"
+	"io"
+	"sink"
+)
+
+// Turns a stream of records back into samples, as though they had just been
+// collected. A collection always starts with line 0 and then requests each
+// further line in turn, until it has as many as line 0 said there were.
+//
+// Records are grouped by router, since collectors for several routers writing
+// to the same file interleave their records. A collection that never finished
+// (because requesting a later line failed, say) is dropped, since it was never
+// sent anywhere when it was collected either.
+type Replayer struct {
+	reader *Reader
+
+	// The collection in progress for each router.
+	pending map[string]*sink.Sample
+}
+
+func NewReplayer(r io.Reader) *Replayer {
+	return &Replayer{reader: NewReader(r), pending: make(map[string]*sink.Sample)}
+}
+
+// Returns the next sample, or io.EOF if there are no more. If the sample can't
+// be parsed, a *ParseError is returned, and the replayer moves on to the next
+// sample on the next call.
+func (r *Replayer) Next() (*sink.Sample, error) {
+	for {
+		record, err := r.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if sample, err := r.add(record); sample != nil || err != nil {
+			return sample, err
+		}
+	}
+}
+
+// Returned by Replayer.Next when a payload in the capture can't be parsed (or
+// is for the wrong line). The replay can carry on after one of these.
+type ParseError struct {
+	Record *Record
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Error parsing capture at %v (firmware %s, line %d): %v", e.Record.Time, e.Record.Firmware, e.Record.Line, e.Err)
+}
+
+// Adds a record to its router's collection, returning the sample if that
+// completes it. The checks are the same as GetStatus makes when collecting, so
+// a collection that failed then fails here too.
+func (r *Replayer) add(record *Record) (*sink.Sample, error) {
+	sample := r.pending[record.Router]
+	delete(r.pending, record.Router)
+
+	if record.Line == 0 {
+		// Anything that was pending never finished.
+		sample = nil
+	} else if sample == nil {
+		return nil, &ParseError{record, fmt.Errorf("Line %d isn't part of a collection that started with line 0", record.Line)}
+	} else if record.Line != len(sample.Lines) {
+		return nil, &ParseError{record, fmt.Errorf("Expected line %d", len(sample.Lines))}
+	}
+
+	profile, err := recordProfile(record)
+	if err != nil {
+		return nil, &ParseError{record, err}
+	}
+
+	status, err := actiontec.ParseStatusWithProfile(record.Payload, profile)
+	if err != nil {
+		return nil, &ParseError{record, err}
+	}
+
+	if !status.IsForLine(record.Line) {
+		return nil, &ParseError{record, fmt.Errorf("Router returned stats for the wrong line")}
+	}
+
+	if sample == nil {
+		sample = &sink.Sample{
+			Source: sink.Source{
+				Router: record.Router,
+				Host:   record.Host,
+				Tags:   record.Tags,
+			},
+			Time:   record.Time,
+			Status: status,
+		}
+	}
+	sample.Lines = append(sample.Lines, status.LineStats)
+
+	// Single line modems may not report any line rates; see IsForLine.
+	lines := len(sample.Status.LineRates)
+	if lines == 0 {
+		lines = 1
+	}
+
+	if len(sample.Lines) < lines {
+		r.pending[record.Router] = sample
+		return nil, nil
+	}
+
+	return sample, nil
+}
+
+// The profile a record should be parsed with: the one it was collected with,
+// if that was set explicitly, or otherwise the one for its firmware, just as
+// when it was collected.
+func recordProfile(record *Record) (*actiontec.Profile, error) {
+	if record.Profile != "" {
+		if p := actiontec.LookupProfile(record.Profile); p != nil {
+			return p, nil
+		}
+		return nil, fmt.Errorf("Unknown profile %s", record.Profile)
+	}
+
+	if p := actiontec.ProfileForFirmware(record.Firmware); p != nil {
+		return p, nil
+	}
+	return actiontec.DefaultProfile, nil
+}
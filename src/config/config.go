@@ -0,0 +1,335 @@
+package config
+
+// Configuration files, for when a handful of flags doesn't cut it: several
+// routers, each with their own credentials and interval, and several outputs.
+//
+// The file is JSON. Any string value can reference an environment variable as
+// ${NAME}, which is the recommended way to get passwords and API keys in
+// without putting them in the file or on the command line.
+
+import (
+	"actiontec"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	Routers []Router `json:"routers"`
+	Outputs []Output `json:"outputs"`
+	Retry   Retry    `json:"retry"`
+	Health  Health   `json:"health"`
+	History History  `json:"history"`
+	Alerts  Alerts   `json:"alerts"`
+}
+
+type Router struct {
+	// Used to identify the router in logs and emitted data. Defaults to Host.
+	Name            string            `json:"name"`
+	Host            string            `json:"host"`
+	Username        string            `json:"username"`
+	Password        string            `json:"password"`
+	Interval        Duration          `json:"interval"`
+	Timeout         Duration          `json:"timeout"`
+	DialTimeout     Duration          `json:"dial_timeout"`
+	ResponseTimeout Duration          `json:"response_timeout"`
+	Record          string            `json:"record"`
+	Profile         string            `json:"profile"` // An actiontec.Profile name; normally picked based on the firmware.
+	Tags            map[string]string `json:"tags"`
+}
+
+// Outputs are a bit of a grab bag: which fields apply depends on Type.
+type Output struct {
+	Type string `json:"type"`
+
+	// insights: Endpoint overrides Region, which is "us" (the default) or "eu".
+	// If Spool is set, undelivered events are kept in that directory, up to
+	// SpoolSize batches.
+	Account   int    `json:"account"`
+	APIKey    string `json:"api_key"`
+	Region    string `json:"region"`
+	Endpoint  string `json:"endpoint"`
+	Spool     string `json:"spool"`
+	SpoolSize int    `json:"spool_size"`
+
+	// insights, and influx over HTTP: how long to wait for each request.
+	Timeout Duration `json:"timeout"`
+
+	// influx: one of URL or File.
+	URL  string `json:"url"`
+	File string `json:"file"`
+
+	// prometheus and dashboard: the address to serve on.
+	Listen string `json:"listen"`
+
+	// prometheus
+	Cache Duration `json:"cache"`
+
+	// dashboard: how much history to keep in memory.
+	Retention Duration `json:"retention"`
+}
+
+type Retry struct {
+	Min             Duration `json:"min"`
+	Max             Duration `json:"max"`
+	PermanentErrors string   `json:"permanent_errors"`
+}
+
+// The health endpoints are only served if Listen is set. We're ready if every
+// router has been collected from, and every sink written to, within the last
+// ReadyIntervals collection intervals.
+type Health struct {
+	Listen         string `json:"listen"`
+	ReadyIntervals int    `json:"ready_intervals"`
+}
+
+// History is only kept on disk if Dir is set. Raw samples are kept for
+// RawRetention, and hourly and daily rollups of them for HourlyRetention and
+// DailyRetention.
+type History struct {
+	Dir             string   `json:"dir"`
+	RawRetention    Duration `json:"raw_retention"`
+	HourlyRetention Duration `json:"hourly_retention"`
+	DailyRetention  Duration `json:"daily_retention"`
+}
+
+// Alert rules, and where to send alerts when they fire and resolve. Alerts are
+// always logged, even if there are no notifiers.
+type Alerts struct {
+	Rules     []AlertRule `json:"rules"`
+	Notifiers []Notifier  `json:"notifiers"`
+}
+
+// A rule applies to a field as named in the history (SignalNoiseMarginDown,
+// LinkUp, and so on), and has exactly one of Below or Above. If Over is set,
+// it applies to how much the field has changed over that period instead.
+type AlertRule struct {
+	Name       string   `json:"name"`
+	Field      string   `json:"field"`
+	Below      *float64 `json:"below"`
+	Above      *float64 `json:"above"`
+	Hysteresis float64  `json:"hysteresis"`
+	For        Duration `json:"for"`
+	Over       Duration `json:"over"`
+}
+
+// As with outputs, which fields apply depends on Type.
+type Notifier struct {
+	Type string `json:"type"`
+
+	// webhook
+	URL string `json:"url"`
+
+	// smtp: Addr is host:port. Username and Password are only needed if the
+	// server wants authentication.
+	Addr     string   `json:"addr"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+
+	// webhook and smtp
+	Timeout Duration `json:"timeout"`
+}
+
+// Durations can be given either as a string that time.ParseDuration
+// understands ("90s", "5m"), or as a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+
+		*d = Duration(parsed)
+		return nil
+	}
+
+	seconds, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("Invalid duration: %s", data)
+	}
+
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load a configuration file and expand any environment variables in it.
+// Defaults aren't applied and the result isn't validated, since flags may
+// still need to be applied on top of it.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+func Parse(data []byte) (*Config, error) {
+	c := new(Config)
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		// Syntax errors only come with a byte offset, which isn't a lot of help
+		// to a human.
+		if serr, ok := err.(*json.SyntaxError); ok {
+			line, col := position(data, serr.Offset)
+			return nil, fmt.Errorf("Syntax error at line %d, column %d: %v", line, col, err)
+		}
+		return nil, err
+	}
+
+	c.expand()
+
+	return c, nil
+}
+
+// Fill in anything that wasn't provided.
+func (c *Config) SetDefaults() {
+	for i := range c.Routers {
+		r := &c.Routers[i]
+
+		if r.Name == "" {
+			r.Name = r.Host
+		}
+		if r.Username == "" {
+			r.Username = "admin"
+		}
+		if r.Interval == 0 {
+			r.Interval = Duration(60 * time.Second)
+		}
+		if r.Timeout == 0 {
+			r.Timeout = Duration(2 * time.Minute)
+		}
+		if r.DialTimeout == 0 {
+			r.DialTimeout = Duration(actiontec.DefaultTimeouts.Dial)
+		}
+		if r.ResponseTimeout == 0 {
+			r.ResponseTimeout = Duration(actiontec.DefaultTimeouts.Response)
+		}
+	}
+
+	for i := range c.Outputs {
+		o := &c.Outputs[i]
+
+		if o.Type == "prometheus" && o.Cache == 0 {
+			o.Cache = Duration(30 * time.Second)
+		}
+		if (o.Type == "insights" || o.Type == "influx") && o.Timeout == 0 {
+			o.Timeout = Duration(30 * time.Second)
+		}
+		if o.Type == "dashboard" && o.Retention == 0 {
+			o.Retention = Duration(48 * time.Hour)
+		}
+		if o.Type == "insights" && o.SpoolSize == 0 {
+			// A week of samples at the default interval.
+			o.SpoolSize = 10080
+		}
+	}
+
+	if c.Retry.Min == 0 {
+		c.Retry.Min = Duration(5 * time.Second)
+	}
+	if c.Retry.Max == 0 {
+		c.Retry.Max = Duration(5 * time.Minute)
+	}
+	if c.Retry.PermanentErrors == "" {
+		c.Retry.PermanentErrors = "exit"
+	}
+
+	if c.Health.ReadyIntervals == 0 {
+		c.Health.ReadyIntervals = 3
+	}
+
+	for i := range c.Alerts.Notifiers {
+		n := &c.Alerts.Notifiers[i]
+
+		if n.Timeout == 0 {
+			n.Timeout = Duration(10 * time.Second)
+		}
+	}
+
+	if c.History.RawRetention == 0 {
+		c.History.RawRetention = Duration(7 * 24 * time.Hour)
+	}
+	if c.History.HourlyRetention == 0 {
+		c.History.HourlyRetention = Duration(90 * 24 * time.Hour)
+	}
+	if c.History.DailyRetention == 0 {
+		c.History.DailyRetention = Duration(10 * 365 * 24 * time.Hour)
+	}
+}
+
+var envRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func expandString(s string) string {
+	return envRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envRegexp.FindStringSubmatch(ref)[1])
+	})
+}
+
+func (c *Config) expand() {
+	for i := range c.Routers {
+		r := &c.Routers[i]
+
+		for _, s := range []*string{&r.Name, &r.Host, &r.Username, &r.Password, &r.Record, &r.Profile} {
+			*s = expandString(*s)
+		}
+		for k, v := range r.Tags {
+			r.Tags[k] = expandString(v)
+		}
+	}
+
+	for i := range c.Outputs {
+		o := &c.Outputs[i]
+
+		for _, s := range []*string{&o.APIKey, &o.Endpoint, &o.Spool, &o.URL, &o.File, &o.Listen} {
+			*s = expandString(*s)
+		}
+	}
+
+	c.Health.Listen = expandString(c.Health.Listen)
+	c.History.Dir = expandString(c.History.Dir)
+
+	for i := range c.Alerts.Notifiers {
+		n := &c.Alerts.Notifiers[i]
+
+		for _, s := range []*string{&n.URL, &n.Addr, &n.From, &n.Username, &n.Password} {
+			*s = expandString(*s)
+		}
+		for j := range n.To {
+			n.To[j] = expandString(n.To[j])
+		}
+	}
+}
+
+// Converts the offset from a json.SyntaxError into a 1-based line and column.
+// The offset points just past the offending character.
+func position(data []byte, offset int64) (line, col int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset > 0 {
+		offset--
+	}
+
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+
+	return
+}
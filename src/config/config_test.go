@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	os.Setenv("CONFIG_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("CONFIG_TEST_PASSWORD")
+
+	c, err := Parse([]byte(`{
+		"routers": [
+			{
+				"host": "192.168.0.1",
+				"password": "${CONFIG_TEST_PASSWORD}",
+				"interval": "90s",
+				"timeout": 45,
+				"tags": {"site": "home"}
+			}
+		],
+		"outputs": [
+			{"type": "prometheus", "listen": ":9101"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+	c.SetDefaults()
+
+	r := c.Routers[0]
+	if r.Name != "192.168.0.1" || r.Username != "admin" || r.Password != "secret" {
+		t.Errorf("Unexpected router: %+v", r)
+	}
+
+	if time.Duration(r.Interval) != 90*time.Second || time.Duration(r.Timeout) != 45*time.Second {
+		t.Errorf("Unexpected durations: %v, %v", r.Interval, r.Timeout)
+	}
+
+	if r.Tags["site"] != "home" {
+		t.Errorf("Unexpected tags: %v", r.Tags)
+	}
+
+	if time.Duration(c.Outputs[0].Cache) != 30*time.Second {
+		t.Errorf("Unexpected cache: %v", c.Outputs[0].Cache)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+
+	errorCases := []struct {
+		input    string
+		expected string
+	}{
+		{`{"routers": [{"hots": "x"}]}`, `unknown field "hots"`},
+		{"{\n  \"routers\": [,]\n}", "line 2, column 15"},
+		{`{"routers": [{"interval": "soon"}]}`, "soon"},
+	}
+
+	for _, c := range errorCases {
+		_, err := Parse([]byte(c.input))
+
+		if err == nil {
+			t.Errorf("Expected an error; got none")
+		} else if !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Expected error to contain %q; got %v", c.expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	six := 6.0
+	c := &Config{
+		Routers: []Router{
+			{Host: "a", Password: "x"},
+			{Host: "a", Interval: Duration(time.Second), Profile: "V9999"},
+		},
+		Outputs: []Output{
+			{Type: "insights", Account: 1},
+			{Type: "influx", URL: "http://localhost", File: "-"},
+			{Type: "dashboard"},
+			{Type: "carrier-pigeon"},
+		},
+		Health:  Health{ReadyIntervals: -1},
+		History: History{HourlyRetention: Duration(-time.Hour)},
+		Alerts: Alerts{
+			Rules: []AlertRule{
+				{Name: "snr", Field: "SignalNoiseMarginDown", Below: &six},
+				{Name: "snr", Field: "Bogus", Below: &six, Above: &six},
+			},
+			Notifiers: []Notifier{
+				{Type: "smtp", Addr: "mail:25", From: "modem@example.com"},
+				{Type: "pager"},
+			},
+		},
+	}
+	c.SetDefaults()
+
+	err := c.Validate()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("Expected a validation error; got %v", err)
+	}
+
+	expected := []string{
+		"routers[1].name",
+		"routers[1].password",
+		"routers[1].interval",
+		"routers[1].profile",
+		"outputs[0].api_key",
+		"outputs[1]",
+		"outputs[2].listen",
+		"outputs[3].type",
+		"health.ready_intervals",
+		"history.hourly_retention",
+		"alerts.rules[1].name",
+		"alerts.rules[1]",
+		"alerts.notifiers[0].to",
+		"alerts.notifiers[1].type",
+	}
+
+	if len(verr) != len(expected) {
+		t.Errorf("Unexpected errors: %v", verr)
+	}
+
+	for i, key := range expected {
+		if i < len(verr) && verr[i].Key != key {
+			t.Errorf("Invalid key: got %s; expected %s", verr[i].Key, key)
+		}
+	}
+}
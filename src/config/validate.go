@@ -0,0 +1,212 @@
+package config
+
+import (
+	"actiontec"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A problem with a specific key in the configuration.
+type FieldError struct {
+	Key     string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Message)
+}
+
+// Every problem found, so they can all be fixed at once.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Check the configuration for problems. Defaults should already have been
+// applied. Returns nil or a ValidationError. Empty lists of routers or outputs
+// aren't errors here, since replaying a capture doesn't need any routers, and
+// the one shot commands don't need any outputs. Alert rule fields are only
+// checked for being there; which names are valid is up to the caller.
+func (c *Config) Validate() error {
+	var errs ValidationError
+	add := func(key, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{key, fmt.Sprintf(format, args...)})
+	}
+
+	names := make(map[string]bool)
+	for i, r := range c.Routers {
+		key := fmt.Sprintf("routers[%d]", i)
+
+		if r.Host == "" {
+			add(key+".host", "must be provided")
+		}
+		if names[r.Name] {
+			add(key+".name", "%q is used by more than one router", r.Name)
+		}
+		names[r.Name] = true
+		if r.Username == "" {
+			add(key+".username", "must be provided")
+		}
+		if r.Password == "" {
+			add(key+".password", "must be provided")
+		}
+		if time.Duration(r.Interval) < 30*time.Second {
+			add(key+".interval", "must be at least 30s")
+		}
+		if r.Timeout <= 0 {
+			add(key+".timeout", "must be positive")
+		}
+		if r.DialTimeout < 0 {
+			add(key+".dial_timeout", "must not be negative")
+		}
+		if r.ResponseTimeout < 0 {
+			add(key+".response_timeout", "must not be negative")
+		}
+		if r.Profile != "" && actiontec.LookupProfile(r.Profile) == nil {
+			add(key+".profile", "must be one of %s", strings.Join(actiontec.ProfileNames(), ", "))
+		}
+	}
+
+	for i, o := range c.Outputs {
+		key := fmt.Sprintf("outputs[%d]", i)
+
+		switch o.Type {
+		case "insights":
+			if o.Account == 0 {
+				add(key+".account", "must be provided")
+			}
+			if o.APIKey == "" {
+				add(key+".api_key", "must be provided")
+			}
+			if o.Region != "" && o.Endpoint != "" {
+				add(key+".endpoint", "only one of region or endpoint may be provided")
+			}
+			if o.Region != "" && o.Region != "us" && o.Region != "eu" {
+				add(key+".region", "must be us or eu")
+			}
+			if o.Timeout < 0 {
+				add(key+".timeout", "must not be negative")
+			}
+			if o.SpoolSize < 0 {
+				add(key+".spool_size", "must not be negative")
+			}
+		case "influx":
+			if (o.URL == "") == (o.File == "") {
+				add(key, "exactly one of url or file must be provided")
+			}
+			if o.Timeout < 0 {
+				add(key+".timeout", "must not be negative")
+			}
+		case "prometheus":
+			if o.Listen == "" {
+				add(key+".listen", "must be provided")
+			}
+			if o.Cache < 0 {
+				add(key+".cache", "must not be negative")
+			}
+		case "dashboard":
+			if o.Listen == "" {
+				add(key+".listen", "must be provided")
+			}
+			if o.Retention < 0 {
+				add(key+".retention", "must not be negative")
+			}
+		case "":
+			add(key+".type", "must be provided")
+		default:
+			add(key+".type", "unknown output type %q", o.Type)
+		}
+	}
+
+	if c.Retry.Min <= 0 {
+		add("retry.min", "must be positive")
+	}
+	if c.Retry.Max < c.Retry.Min {
+		add("retry.max", "must be at least retry.min")
+	}
+	if c.Retry.PermanentErrors != "exit" && c.Retry.PermanentErrors != "retry" {
+		add("retry.permanent_errors", "must be exit or retry")
+	}
+
+	if c.Health.ReadyIntervals < 1 {
+		add("health.ready_intervals", "must be at least 1")
+	}
+
+	if c.History.RawRetention < 0 {
+		add("history.raw_retention", "must not be negative")
+	}
+	if c.History.HourlyRetention < 0 {
+		add("history.hourly_retention", "must not be negative")
+	}
+	if c.History.DailyRetention < 0 {
+		add("history.daily_retention", "must not be negative")
+	}
+
+	rules := make(map[string]bool)
+	for i, r := range c.Alerts.Rules {
+		key := fmt.Sprintf("alerts.rules[%d]", i)
+
+		if r.Name == "" {
+			add(key+".name", "must be provided")
+		} else if rules[r.Name] {
+			add(key+".name", "%q is used by more than one rule", r.Name)
+		}
+		rules[r.Name] = true
+		if r.Field == "" {
+			add(key+".field", "must be provided")
+		}
+		if (r.Below == nil) == (r.Above == nil) {
+			add(key, "exactly one of below or above must be provided")
+		}
+		if r.Hysteresis < 0 {
+			add(key+".hysteresis", "must not be negative")
+		}
+		if r.For < 0 {
+			add(key+".for", "must not be negative")
+		}
+		if r.Over < 0 {
+			add(key+".over", "must not be negative")
+		}
+	}
+
+	for i, n := range c.Alerts.Notifiers {
+		key := fmt.Sprintf("alerts.notifiers[%d]", i)
+
+		switch n.Type {
+		case "webhook":
+			if n.URL == "" {
+				add(key+".url", "must be provided")
+			}
+		case "smtp":
+			if n.Addr == "" {
+				add(key+".addr", "must be provided")
+			}
+			if n.From == "" {
+				add(key+".from", "must be provided")
+			}
+			if len(n.To) == 0 {
+				add(key+".to", "must be provided")
+			}
+		case "sinks":
+		case "":
+			add(key+".type", "must be provided")
+		default:
+			add(key+".type", "unknown notifier type %q", n.Type)
+		}
+		if n.Timeout < 0 {
+			add(key+".timeout", "must not be negative")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
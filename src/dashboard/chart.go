@@ -0,0 +1,237 @@
+package dashboard
+
+// Just enough SVG charting for line stats: time on the x axis, a few series of
+// numbers on the y axis, and nothing fetched from anywhere else.
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	chartWidth   = 720
+	chartHeight  = 180
+	marginLeft   = 60
+	marginRight  = 10
+	marginTop    = 10
+	marginBottom = 24
+
+	// The link state chart is a single band.
+	stateHeight = 24
+)
+
+type xy struct {
+	t time.Time
+	v float64
+}
+
+// Class picks the colour, from the page's stylesheet.
+type series struct {
+	class  string
+	points []xy
+}
+
+type chart struct {
+	title    string
+	from, to time.Time
+	series   []series
+}
+
+// Where the plot area is, once the margins are taken off.
+func plotWidth() float64 {
+	return chartWidth - marginLeft - marginRight
+}
+
+func (c *chart) x(t time.Time) float64 {
+	return marginLeft + plotWidth()*float64(t.Sub(c.from))/float64(c.to.Sub(c.from))
+}
+
+// Points further apart than this are assumed to have something missing
+// between them (the collector being down, say), so the line isn't drawn
+// across the gap. There's no way to know the collection interval from here,
+// so it's guessed from the typical spacing of the points.
+func gapThreshold(points []xy) time.Duration {
+	if len(points) < 2 {
+		return 0
+	}
+
+	deltas := make([]time.Duration, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		deltas[i-1] = points[i].t.Sub(points[i-1].t)
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+
+	return 3 * deltas[len(deltas)/2]
+}
+
+// Renders the chart as a line chart.
+func (c *chart) lines() template.HTML {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range c.series {
+		for _, p := range s.points {
+			lo = math.Min(lo, p.v)
+			hi = math.Max(hi, p.v)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		lo, hi = 0, 1
+	}
+
+	ticks := yTicks(lo, hi)
+	lo, hi = ticks[0], ticks[len(ticks)-1]
+	plotHeight := float64(chartHeight - marginTop - marginBottom)
+	y := func(v float64) float64 {
+		return marginTop + plotHeight*(1-(v-lo)/(hi-lo))
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, `<svg class="chart" viewBox="0 0 %d %d" role="img"><title>%s</title>`, chartWidth, chartHeight, template.HTMLEscapeString(c.title))
+
+	for _, tick := range ticks {
+		fmt.Fprintf(b, `<line class="grid" x1="%d" x2="%d" y1="%.1f" y2="%.1f"/>`, marginLeft, chartWidth-marginRight, y(tick), y(tick))
+		fmt.Fprintf(b, `<text class="label" x="%d" y="%.1f" text-anchor="end">%s</text>`, marginLeft-6, y(tick)+4, formatValue(tick))
+	}
+	c.xAxis(b, chartHeight-marginBottom)
+
+	for _, s := range c.series {
+		gap := gapThreshold(s.points)
+
+		var segment []xy
+		flush := func() {
+			if len(segment) == 1 {
+				// A lone point wouldn't show up as a line.
+				fmt.Fprintf(b, `<circle class="%s" cx="%.1f" cy="%.1f" r="2"/>`, s.class, c.x(segment[0].t), y(segment[0].v))
+			} else if len(segment) > 1 {
+				coords := make([]string, len(segment))
+				for i, p := range segment {
+					coords[i] = fmt.Sprintf("%.1f,%.1f", c.x(p.t), y(p.v))
+				}
+				fmt.Fprintf(b, `<polyline class="%s" points="%s"/>`, s.class, strings.Join(coords, " "))
+			}
+			segment = segment[:0]
+		}
+
+		for i, p := range s.points {
+			if i > 0 && p.t.Sub(s.points[i-1].t) > gap {
+				flush()
+			}
+			segment = append(segment, p)
+		}
+		flush()
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// Renders the chart as a band that's green while the value is 1 and red while
+// it's 0, for link state. Only the first series is used.
+func (c *chart) band() template.HTML {
+	b := new(strings.Builder)
+	height := marginTop + stateHeight + marginBottom
+	fmt.Fprintf(b, `<svg class="chart" viewBox="0 0 %d %d" role="img"><title>%s</title>`, chartWidth, height, template.HTMLEscapeString(c.title))
+	fmt.Fprintf(b, `<rect class="nodata" x="%d" y="%d" width="%.1f" height="%d"/>`, marginLeft, marginTop, plotWidth(), stateHeight)
+
+	if len(c.series) > 0 {
+		points := c.series[0].points
+		gap := gapThreshold(points)
+
+		// Each point covers the time until the next one, or until we'd assume
+		// something's missing.
+		for i, p := range points {
+			end := c.to
+			if i+1 < len(points) {
+				end = points[i+1].t
+			}
+			if gap > 0 && end.Sub(p.t) > gap {
+				end = p.t.Add(gap / 3)
+			}
+
+			class := "down"
+			if p.v >= 1 {
+				class = "up"
+			}
+
+			x1, x2 := c.x(p.t), c.x(end)
+			fmt.Fprintf(b, `<rect class="%s" x="%.1f" y="%d" width="%.1f" height="%d"/>`, class, x1, marginTop, math.Max(x2-x1, 1), stateHeight)
+		}
+	}
+
+	c.xAxis(b, marginTop+stateHeight)
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// Nice round intervals for the time axis, smallest first.
+var timeSteps = []time.Duration{
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+func (c *chart) xAxis(b *strings.Builder, y int) {
+	span := c.to.Sub(c.from)
+	step := timeSteps[len(timeSteps)-1]
+	for _, s := range timeSteps {
+		if span/s <= 8 {
+			step = s
+			break
+		}
+	}
+
+	layout := "15:04"
+	if step >= 24*time.Hour {
+		layout = "Jan 2"
+	}
+
+	// Truncate works in UTC; that's fine for anything under a day, and close
+	// enough for days.
+	for t := c.from.Truncate(step).Add(step); !t.After(c.to); t = t.Add(step) {
+		x := c.x(t)
+		fmt.Fprintf(b, `<line class="grid" x1="%.1f" x2="%.1f" y1="%d" y2="%d"/>`, x, x, marginTop, y)
+		fmt.Fprintf(b, `<text class="label" x="%.1f" y="%d" text-anchor="middle">%s</text>`, x, y+16, t.Local().Format(layout))
+	}
+}
+
+// Picks four or so evenly spaced, round values covering lo to hi.
+func yTicks(lo, hi float64) []float64 {
+	if hi-lo < 1e-9 {
+		lo, hi = lo-1, hi+1
+	}
+
+	step := math.Pow(10, math.Floor(math.Log10((hi-lo)/4)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if (hi-lo)/(step*m) <= 5 {
+			step *= m
+			break
+		}
+	}
+
+	start := math.Floor(lo/step) * step
+	ticks := []float64{start}
+	for i := 1; ticks[len(ticks)-1] < hi-1e-9; i++ {
+		ticks = append(ticks, start+float64(i)*step)
+	}
+
+	return ticks
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.Abs(v) >= 1e6:
+		return fmt.Sprintf("%gM", v/1e6)
+	case math.Abs(v) >= 1e4:
+		return fmt.Sprintf("%gk", v/1e3)
+	default:
+		return fmt.Sprintf("%g", math.Round(v*100)/100)
+	}
+}
@@ -0,0 +1,291 @@
+package dashboard
+
+// A small web UI for people who don't have (or want) New Relic: the latest
+// stats for each line, and charts of how they've been doing over the last few
+// hours or days. Everything is rendered on the server, and the page doesn't
+// load anything from anywhere else, so it works on a LAN with no Internet
+// connection, which is exactly when you'll want to look at it.
+
+import (
+	"bytes"
+	"fmt"
+	"history"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The time ranges that can be shown, in the order they're offered.
+var ranges = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+const defaultRange = "24h"
+
+type Handler struct {
+	history history.Reader
+
+	// Only replaced by the tests.
+	now func() time.Time
+}
+
+func New(h history.Reader) *Handler {
+	return &Handler{history: h, now: time.Now}
+}
+
+// What the template gets.
+type page struct {
+	Routers []string
+	Router  string
+	Ranges  []string
+	Range   string
+	Latest  *latest
+	Lines   []lineCharts
+}
+
+type latest struct {
+	Time  time.Time
+	Lines []history.Values
+}
+
+type lineCharts struct {
+	Line   int
+	Charts []chartView
+}
+
+type chartView struct {
+	Title  string
+	Legend []legendEntry
+	SVG    template.HTML
+}
+
+type legendEntry struct {
+	Name  string
+	Class string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	routers, err := h.history.Routers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p := &page{Routers: routers, Router: r.FormValue("router"), Range: r.FormValue("range")}
+	for _, rng := range ranges {
+		p.Ranges = append(p.Ranges, rng.Name)
+	}
+
+	if p.Router == "" && len(routers) > 0 {
+		p.Router = routers[0]
+	}
+	if p.Range == "" {
+		p.Range = defaultRange
+	}
+
+	var span time.Duration
+	for _, rng := range ranges {
+		if rng.Name == p.Range {
+			span = rng.Duration
+		}
+	}
+	if span == 0 {
+		http.Error(w, fmt.Sprintf("Unknown range: %s", p.Range), http.StatusBadRequest)
+		return
+	}
+
+	to := h.now()
+	from := to.Add(-span)
+	points, err := h.history.Query(p.Router, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(points) > 0 {
+		last := points[len(points)-1]
+		p.Latest = &latest{Time: last.Time, Lines: last.Lines}
+	}
+	p.Lines = charts(points, from, to)
+
+	// Render to a buffer first, so a template error doesn't leave a half
+	// written page behind a 200.
+	buffer := new(bytes.Buffer)
+	if err := pageTemplate.Execute(buffer, p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buffer.Bytes())
+}
+
+// A series in a chart: what it's called in the legend, and the history field
+// it's drawn from.
+type fieldSpec struct {
+	name  string
+	field string
+}
+
+// The charts drawn for each line, and the fields that go in them.
+var chartSpecs = []struct {
+	title  string
+	fields []fieldSpec
+	band   bool
+}{
+	{"Link state", []fieldSpec{{"Up", "LinkUp"}}, true},
+	{"Rate (kbps)", []fieldSpec{{"Down", "RateDown"}, {"Up", "RateUp"}}, false},
+	{"SNR margin (dB)", []fieldSpec{{"Down", "SignalNoiseMarginDown"}, {"Up", "SignalNoiseMarginUp"}}, false},
+	{"Attenuation (dB)", []fieldSpec{{"Down", "AttenuationDown"}, {"Up", "AttenuationUp"}}, false},
+	{"Retrains", []fieldSpec{{"Retrains", "Retrains"}}, false},
+}
+
+// Series colours, from the stylesheet, in the order the fields are listed
+// above.
+var seriesClasses = []string{"s0", "s1"}
+
+func charts(points []history.Point, from, to time.Time) []lineCharts {
+	lines := 0
+	for _, p := range points {
+		if len(p.Lines) > lines {
+			lines = len(p.Lines)
+		}
+	}
+
+	result := make([]lineCharts, lines)
+	for line := range result {
+		result[line].Line = line
+
+		for _, spec := range chartSpecs {
+			c := &chart{title: spec.title, from: from, to: to}
+			view := chartView{Title: spec.title}
+
+			for i, field := range spec.fields {
+				s := series{class: seriesClasses[i]}
+				for _, p := range points {
+					if line >= len(p.Lines) {
+						continue
+					}
+					if v, ok := p.Lines[line][field.field]; ok {
+						s.points = append(s.points, xy{p.Time, v})
+					}
+				}
+
+				c.series = append(c.series, s)
+				view.Legend = append(view.Legend, legendEntry{field.name, s.class})
+			}
+
+			if spec.band {
+				view.SVG = c.band()
+				view.Legend = nil
+			} else {
+				view.SVG = c.lines()
+			}
+			result[line].Charts = append(result[line].Charts, view)
+		}
+	}
+
+	return result
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"value": func(v history.Values, name string) string {
+		if x, ok := v[name]; ok {
+			return strconv.FormatFloat(x, 'f', -1, 64)
+		}
+		return "-"
+	},
+	"state": func(v history.Values) string {
+		if v["LinkUp"] == 1 {
+			return "Up"
+		}
+		return "Down"
+	},
+	"uptime": func(v history.Values) string {
+		return (time.Duration(v["Uptime"]) * time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>{{with .Router}}{{.}} - {{end}}Line stats</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 760px; color: #222; }
+nav a { margin-right: 0.5em; }
+nav a.current { font-weight: bold; text-decoration: none; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { padding: 0.2em 0.8em; text-align: right; border-bottom: 1px solid #ddd; }
+h3 { margin-bottom: 0; font-size: 1em; }
+.legend span { margin-left: 1em; font-weight: normal; }
+.chart { width: 100%; height: auto; }
+.grid { stroke: #eee; }
+.label { font-size: 11px; fill: #777; }
+polyline { fill: none; stroke-width: 1.5; }
+.s0 { stroke: #1f77b4; fill: #1f77b4; }
+.s1 { stroke: #ff7f0e; fill: #ff7f0e; }
+polyline.s0, polyline.s1 { fill: none; }
+.legend .s0, .legend .s1 { stroke: none; }
+.legend .s0 { color: #1f77b4; }
+.legend .s1 { color: #ff7f0e; }
+.up { fill: #2ca02c; }
+.down { fill: #d62728; }
+.nodata { fill: #eee; }
+.Up { color: #2ca02c; }
+.Down { color: #d62728; }
+</style>
+</head>
+<body>
+<h1>Line stats</h1>
+{{if not .Routers}}
+<p>Nothing has been collected yet. This page refreshes every minute.</p>
+{{else}}
+<nav>
+{{range .Routers}}<a href="?router={{.}}&amp;range={{$.Range}}"{{if eq . $.Router}} class="current"{{end}}>{{.}}</a>{{end}}
+|
+{{range .Ranges}}<a href="?router={{$.Router}}&amp;range={{.}}"{{if eq . $.Range}} class="current"{{end}}>{{.}}</a>{{end}}
+</nav>
+{{with .Latest}}
+<p>Last collected {{.Time.Local.Format "2006-01-02 15:04:05"}}.</p>
+<table>
+<tr><th>Line</th><th>State</th><th>Rate up/down</th><th>SNR up/down</th><th>Atten up/down</th><th>Retrains</th><th>Uptime</th></tr>
+{{range $i, $v := .Lines}}
+<tr>
+<td>{{$i}}</td>
+<td class="{{state $v}}">{{state $v}}</td>
+<td>{{value $v "RateUp"}}/{{value $v "RateDown"}} kbps</td>
+<td>{{value $v "SignalNoiseMarginUp"}}/{{value $v "SignalNoiseMarginDown"}} dB</td>
+<td>{{value $v "AttenuationUp"}}/{{value $v "AttenuationDown"}} dB</td>
+<td>{{value $v "Retrains"}}</td>
+<td>{{uptime $v}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>Nothing has been collected from {{.Router}} in the last {{.Range}}.</p>
+{{end}}
+{{range .Lines}}
+<h2>Line {{.Line}}</h2>
+{{range .Charts}}
+<h3>{{.Title}}<span class="legend">{{range .Legend}}<span class="{{.Class}}">&#9644; {{.Name}}</span>{{end}}</span></h3>
+{{.SVG}}
+{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
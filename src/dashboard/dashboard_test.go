@@ -0,0 +1,107 @@
+package dashboard
+
+import (
+	"actiontec"
+	"history"
+	"net/http"
+	"net/http/httptest"
+	"sink"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	h := history.NewMemory(24 * time.Hour)
+
+	for i := 0; i < 60; i++ {
+		state := actiontec.Up
+		if i == 30 {
+			state = actiontec.Down
+		}
+
+		// Leave a gap, which shouldn't be drawn across.
+		if i >= 40 && i < 50 {
+			continue
+		}
+
+		h.Send(&sink.Sample{
+			Source: sink.Source{Router: "home"},
+			Time:   now.Add(time.Duration(i-59) * time.Minute),
+			Status: &actiontec.Status{},
+			Lines: []actiontec.LineStats{
+				{State: state, Rates: actiontec.Rates{Up: 10000, Down: 50000}, SignalNoiseMargin: actiontec.UintPair{Up: 7, Down: 9}},
+				{State: actiontec.Up},
+			},
+		})
+	}
+
+	d := New(h)
+	d.now = func() time.Time { return now }
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/?range=1h")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected OK; got %d: %s", w.Code, w.Body)
+	}
+
+	body := w.Body.String()
+	for _, expected := range []string{
+		"<h2>Line 0</h2>",
+		"<h2>Line 1</h2>",
+		"10000/50000 kbps",
+		`class="down"`,
+		"<polyline",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the page to contain %q", expected)
+		}
+	}
+
+	// Five charts per line, two series each for three of them; each series is
+	// split into two by the gap.
+	if n := strings.Count(body, "<polyline"); n != 2*(3*2+1)*2 {
+		t.Errorf("Unexpected number of polylines: %d", n)
+	}
+
+	// Nothing should come from anywhere else.
+	if strings.Contains(body, "http://") || strings.Contains(body, "https://") || strings.Contains(body, "<script") {
+		t.Errorf("The page refers to something external: %s", body)
+	}
+
+	if w := get("/?router=work"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Nothing has been collected from work") {
+		t.Errorf("Expected an empty page for an unknown router; got %d", w.Code)
+	}
+
+	errorCases := []string{"/?range=1y", "/nope"}
+	for _, path := range errorCases {
+		if w := get(path); w.Code == http.StatusOK {
+			t.Errorf("Expected an error for %s; got OK", path)
+		}
+	}
+}
+
+func TestYTicks(t *testing.T) {
+	cases := []struct {
+		lo, hi float64
+	}{
+		{0, 1},
+		{6, 9},
+		{22.7, 27.4},
+		{10000, 52000},
+		{5, 5},
+	}
+
+	for _, c := range cases {
+		ticks := yTicks(c.lo, c.hi)
+		if len(ticks) < 2 || len(ticks) > 7 || ticks[0] > c.lo || ticks[len(ticks)-1] < c.hi {
+			t.Errorf("Invalid ticks for %v-%v: %v", c.lo, c.hi, ticks)
+		}
+	}
+}
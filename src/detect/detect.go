@@ -0,0 +1,111 @@
+package detect
+
+// Detection of interesting things happening between samples: lines retraining,
+// going down and coming back up, and the modem rebooting. The raw stats only
+// give point in time values, so spotting these otherwise means eyeballing
+// max(Retrains) on a dashboard.
+
+import (
+	"actiontec"
+	"sink"
+)
+
+// Event types.
+const (
+	LineRetrained = "LineRetrained"
+	LineWentDown  = "LineWentDown"
+	LineCameUp    = "LineCameUp"
+	ModemRebooted = "ModemRebooted"
+)
+
+// Compares each sample from a router with the previous one. A detector holds
+// state for a single router, and isn't safe for concurrent use.
+type Detector struct {
+	previous *sink.Sample
+}
+
+func New() *Detector {
+	return new(Detector)
+}
+
+// Returns the events that happened between the previous sample and this one.
+// The first sample never generates any events.
+func (d *Detector) Observe(sample *sink.Sample) []*sink.Event {
+	previous := d.previous
+	d.previous = sample
+
+	if previous == nil {
+		return nil
+	}
+
+	var events []*sink.Event
+	newEvent := func(typ string, attrs map[string]interface{}) *sink.Event {
+		attrs["SoftwareVersion"] = sample.Status.SoftwareVersion
+
+		return &sink.Event{
+			Source:     sample.Source,
+			Time:       sample.Time,
+			Type:       typ,
+			Attributes: attrs,
+		}
+	}
+
+	// A reboot resets all the counters, so there's no point comparing them:
+	// everything will have gone backwards.
+	rebooted := sample.Status.ModemUptime < previous.Status.ModemUptime
+	if rebooted {
+		events = append(events, newEvent(ModemRebooted, map[string]interface{}{
+			"UptimeBefore": uint64(previous.Status.ModemUptime.Seconds()),
+			"UptimeAfter":  uint64(sample.Status.ModemUptime.Seconds()),
+		}))
+	}
+
+	for i, after := range sample.Lines {
+		if i >= len(previous.Lines) {
+			break
+		}
+		before := previous.Lines[i]
+
+		if before.State == actiontec.Up && after.State != actiontec.Up {
+			events = append(events, newEvent(LineWentDown, lineAttributes(i, &before, &after)))
+		} else if before.State != actiontec.Up && after.State == actiontec.Up {
+			events = append(events, newEvent(LineCameUp, lineAttributes(i, &before, &after)))
+		}
+
+		if !rebooted && retrained(&before, &after) {
+			attrs := lineAttributes(i, &before, &after)
+			attrs["Retrains"] = retrains(&before, &after)
+			events = append(events, newEvent(LineRetrained, attrs))
+		}
+	}
+
+	return events
+}
+
+// A line has retrained if its retrain counter went up, or if its uptime went
+// backwards (which catches a retrain that the counter missed, which does seem
+// to happen if the line drops for long enough).
+func retrained(before, after *actiontec.LineStats) bool {
+	return after.Retrains > before.Retrains || (after.State == actiontec.Up && after.Uptime < before.Uptime)
+}
+
+func retrains(before, after *actiontec.LineStats) uint64 {
+	if after.Retrains > before.Retrains {
+		return after.Retrains - before.Retrains
+	}
+	return 1
+}
+
+func lineAttributes(line int, before, after *actiontec.LineStats) map[string]interface{} {
+	return map[string]interface{}{
+		"Line":                        line,
+		"RateUpBefore":                before.Rates.Up,
+		"RateUpAfter":                 after.Rates.Up,
+		"RateDownBefore":              before.Rates.Down,
+		"RateDownAfter":               after.Rates.Down,
+		"SignalNoiseMarginUpBefore":   before.SignalNoiseMargin.Up,
+		"SignalNoiseMarginUpAfter":    after.SignalNoiseMargin.Up,
+		"SignalNoiseMarginDownBefore": before.SignalNoiseMargin.Down,
+		"SignalNoiseMarginDownAfter":  after.SignalNoiseMargin.Down,
+	}
+}
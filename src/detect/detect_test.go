@@ -0,0 +1,98 @@
+package detect
+
+import (
+	"actiontec"
+	"sink"
+	"testing"
+	"time"
+)
+
+func sample(uptime int, lines ...actiontec.LineStats) *sink.Sample {
+	return &sink.Sample{
+		Time:   time.Unix(1500000000, 0),
+		Source: sink.Source{Router: "home"},
+		Status: &actiontec.Status{ModemUptime: time.Duration(uptime) * time.Second},
+		Lines:  lines,
+	}
+}
+
+func line(state actiontec.State, retrains uint64, uptime int) actiontec.LineStats {
+	return actiontec.LineStats{
+		State:    state,
+		Rates:    actiontec.Rates{Up: 1000, Down: 5000},
+		Retrains: retrains,
+		Uptime:   time.Duration(uptime) * time.Second,
+	}
+}
+
+func TestObserve(t *testing.T) {
+	cases := []struct {
+		before   *sink.Sample
+		after    *sink.Sample
+		expected []string
+	}{
+		{
+			sample(100, line(actiontec.Up, 0, 100), line(actiontec.Up, 0, 100)),
+			sample(160, line(actiontec.Up, 0, 160), line(actiontec.Up, 0, 160)),
+			nil,
+		},
+		{
+			sample(100, line(actiontec.Up, 0, 100), line(actiontec.Up, 0, 100)),
+			sample(160, line(actiontec.Up, 0, 160), line(actiontec.Up, 1, 10)),
+			[]string{LineRetrained},
+		},
+		{
+			sample(100, line(actiontec.Up, 0, 100), line(actiontec.Up, 0, 100)),
+			sample(160, line(actiontec.Up, 0, 5), line(actiontec.Up, 0, 160)),
+			[]string{LineRetrained},
+		},
+		{
+			sample(100, line(actiontec.Up, 0, 100), line(actiontec.Up, 0, 100)),
+			sample(160, line(actiontec.Up, 0, 160), line(actiontec.Down, 0, 0)),
+			[]string{LineWentDown},
+		},
+		{
+			sample(100, line(actiontec.Up, 0, 100), line(actiontec.EstablishingLink, 0, 0)),
+			sample(160, line(actiontec.Up, 0, 160), line(actiontec.Up, 1, 30)),
+			[]string{LineCameUp, LineRetrained},
+		},
+		{
+			sample(1000, line(actiontec.Up, 5, 1000), line(actiontec.Up, 3, 1000)),
+			sample(60, line(actiontec.Up, 0, 30), line(actiontec.Down, 0, 0)),
+			[]string{ModemRebooted, LineWentDown},
+		},
+	}
+
+	for i, c := range cases {
+		d := New()
+
+		if events := d.Observe(c.before); events != nil {
+			t.Errorf("Case %d: expected no events from the first sample; got %v", i, events)
+		}
+
+		events := d.Observe(c.after)
+		if len(events) != len(c.expected) {
+			t.Errorf("Case %d: unexpected events: %v", i, events)
+			continue
+		}
+
+		for j, e := range events {
+			if e.Type != c.expected[j] {
+				t.Errorf("Case %d: got %s; expected %s", i, e.Type, c.expected[j])
+			}
+			if e.Router != "home" {
+				t.Errorf("Case %d: got router %s", i, e.Router)
+			}
+		}
+	}
+}
+
+func TestRetrainCount(t *testing.T) {
+	d := New()
+	d.Observe(sample(100, line(actiontec.Up, 2, 100)))
+	events := d.Observe(sample(160, line(actiontec.Up, 5, 10)))
+
+	if len(events) != 1 || events[0].Attributes["Retrains"] != uint64(3) {
+		t.Errorf("Unexpected events: %v", events)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+// A standalone fake router, for developing against when you don't have a modem
+// handy. Each argument is a frame file (one refresh payload per bonded line);
+// the router moves on to the next frame every -advance.
+
+import (
+	"fakerouter"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+)
+
+var advance time.Duration
+var listen string
+var password string
+var username string
+
+func init() {
+	flag.DurationVar(&advance, "advance", time.Minute, "how often to move to the next frame")
+	flag.StringVar(&listen, "listen", "127.0.0.1:8080", "address to listen on")
+	flag.StringVar(&password, "password", "password", "admin password to accept")
+	flag.StringVar(&username, "username", "admin", "admin user name to accept")
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("At least one frame file must be provided.")
+	}
+
+	var frames [][]string
+	for _, path := range flag.Args() {
+		frame, err := fakerouter.LoadFrame(path)
+		if err != nil {
+			log.Fatalf("Error loading frame: %v", err)
+		}
+
+		frames = append(frames, frame)
+	}
+
+	router := fakerouter.New(username, password, frames...)
+
+	go func() {
+		for _ = range time.Tick(advance) {
+			router.Advance()
+		}
+	}()
+
+	log.Printf("Fake router listening on %s", listen)
+	log.Fatal(http.ListenAndServe(listen, router))
+}
@@ -0,0 +1,171 @@
+package fakerouter
+
+// A fake Actiontec router, for testing and for working on this thing without a
+// modem on the LAN. It emulates the four pages we actually use, including the
+// quirks that make the real thing annoying to deal with:
+//
+//   * Sessions are tracked by client IP address, not cookies.
+//   * A failed login is only signalled by "msg=err" in the response.
+//   * The selected bonded line is global state shared by every client, and is
+//     only changed by posting to modemstatus_wanstatus.cgi.
+//
+// The refresh payloads themselves are scripted: the router holds a list of
+// frames, each of which has one payload per bonded line, and serves the
+// current frame until Advance is called.
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Router struct {
+	username string
+	password string
+
+	mu       sync.Mutex
+	sessions map[string]bool
+	line     int
+	frames   [][]string
+	frame    int
+}
+
+// Create a router that accepts the given credentials and serves the given
+// frames. Each frame is a slice of refresh payloads, indexed by line.
+func New(username, password string, frames ...[]string) *Router {
+	return &Router{
+		username: username,
+		password: password,
+		sessions: make(map[string]bool),
+		frames:   frames,
+	}
+}
+
+// Move on to the next frame. Once the last frame is reached, it's served
+// forever.
+func (r *Router) Advance() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.frame < len(r.frames)-1 {
+		r.frame++
+	}
+}
+
+// Switch the selected line, as though someone else had the UI open.
+func (r *Router) SelectLine(line int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.line = line
+}
+
+// Whether the given IP address currently has a session.
+func (r *Router) LoggedIn(ip string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.sessions[ip]
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch req.URL.Path {
+	case "/login.cgi":
+		r.login(w, req, ip)
+	case "/logout.cgi":
+		delete(r.sessions, ip)
+		fmt.Fprint(w, loginPage)
+	case "/modemstatus_wanstatus.cgi":
+		if !r.sessions[ip] {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+
+		// The real router accepts just about anything here, and so do we.
+		if line, err := strconv.Atoi(req.FormValue("bondingLineNum")); err == nil {
+			r.line = line
+		}
+		fmt.Fprint(w, statusPage)
+	case "/modemstatus_wanstatus_refresh.html":
+		if !r.sessions[ip] {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+
+		fmt.Fprint(w, r.payload())
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *Router) login(w http.ResponseWriter, req *http.Request, ip string) {
+	if req.FormValue("inputUserName") != r.username || req.FormValue("inputPassword") != r.password {
+		fmt.Fprintf(w, redirectPage, "login.html?msg=err")
+		return
+	}
+
+	r.sessions[ip] = true
+	fmt.Fprintf(w, redirectPage, "index.html")
+}
+
+// Must be called with the lock held. Lines without a payload get an empty
+// response, which is roughly what the real router does when you ask for a line
+// that doesn't exist.
+func (r *Router) payload() string {
+	if r.frame >= len(r.frames) {
+		return ""
+	}
+
+	frame := r.frames[r.frame]
+	if r.line < 0 || r.line >= len(frame) {
+		return ""
+	}
+
+	return frame[r.line]
+}
+
+// Load a frame from a file containing one refresh payload per line of text.
+func LoadFrame(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var frame []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			frame = append(frame, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(frame) == 0 {
+		return nil, fmt.Errorf("No payloads found in %s", path)
+	}
+
+	return frame, nil
+}
+
+// Just enough HTML to look like the real thing to anyone reading it.
+const redirectPage = `<html><head><script language="javascript">window.location.href = "%s";</script></head><body></body></html>`
+const loginPage = `<html><head><title>Login</title></head><body><form action="login.cgi" method="post"></form></body></html>`
+const statusPage = `<html><head><title>Modem Status</title></head><body></body></html>`
@@ -0,0 +1,147 @@
+package fakerouter
+
+import (
+	"actiontec"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Builds a two line refresh payload for the given selected line, with line 0
+// at 10000/50000 and line 1 at 8000/40000.
+func payload(line int, retrains int) string {
+	stats := []string{
+		fmt.Sprintf("Up|PTM|50000|10000|9/7|(DS1)26.6 /(US1)13.1|%d|1000|0", retrains),
+		fmt.Sprintf("Up|PTM|40000|8000|6/5|(DS1)30.2 /(US1)15.0|%d|900|0", retrains),
+	}
+
+	return "0+18000+90000+T2200H-31.128L.03+" + stats[line] +
+		"+3+1+2+3+4+60+0+5000+1000|10|2000|20+12|0+34|0+1|0+2|0+560|0+78|0+9|0+4|0+5|6+7|8+1|2+Up|10000|50000+Up|8000|40000+"
+}
+
+func newServer(frames ...[]string) (*Router, *httptest.Server, *actiontec.Context) {
+	router := New("admin", "password", frames...)
+	server := httptest.NewServer(router)
+
+	ctx, err := actiontec.NewContext(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		panic(err)
+	}
+
+	return router, server, ctx
+}
+
+func TestLogin(t *testing.T) {
+	router, server, ctx := newServer([]string{payload(0, 0), payload(1, 0)})
+	defer server.Close()
+
+	if err := ctx.Login("admin", "wrong"); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+
+	if router.LoggedIn("127.0.0.1") {
+		t.Errorf("Expected to not be logged in after a bad password")
+	}
+
+	if err := ctx.Login("admin", "password"); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if !router.LoggedIn("127.0.0.1") {
+		t.Errorf("Expected to be logged in")
+	}
+
+	if err := ctx.Logout(); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if router.LoggedIn("127.0.0.1") {
+		t.Errorf("Expected to be logged out")
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	router, server, ctx := newServer(
+		[]string{payload(0, 0), payload(1, 0)},
+		[]string{payload(0, 1), payload(1, 1)},
+	)
+	defer server.Close()
+
+	// Not logged in: we get the login page, which doesn't parse.
+	if _, _, err := ctx.GetStatus(); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+
+	if err := ctx.Login("admin", "password"); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	status, lines, err := ctx.GetStatus()
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if status.TotalRate != (actiontec.Rates{Up: 18000, Down: 90000}) {
+		t.Errorf("Invalid total rate: got %v", status.TotalRate)
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected number of lines: %d", len(lines))
+	}
+
+	for i, expected := range []actiontec.Rates{{Up: 10000, Down: 50000}, {Up: 8000, Down: 40000}} {
+		if lines[i].Rates != expected {
+			t.Errorf("Line %d: got rates %v; expected %v", i, lines[i].Rates, expected)
+		}
+	}
+
+	router.Advance()
+
+	_, lines, err = ctx.GetStatus()
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	for i, line := range lines {
+		if line.Retrains != 1 {
+			t.Errorf("Line %d: got %d retrains; expected 1", i, line.Retrains)
+		}
+	}
+}
+
+func TestGetStatusWrongLine(t *testing.T) {
+	// A router that ignores line selection and always returns line 1 should
+	// be caught.
+	_, server, ctx := newServer([]string{payload(1, 0), payload(1, 0)})
+	defer server.Close()
+
+	if err := ctx.Login("admin", "password"); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if _, _, err := ctx.GetStatus(); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	_, server, ctx := newServer([]string{payload(0, 0), payload(1, 0)})
+	defer server.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := ctx.LoginContext(cancelled, "admin", "password"); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+
+	if err := ctx.Login("admin", "password"); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if _, _, err := ctx.GetStatusContext(cancelled); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
@@ -0,0 +1,332 @@
+package health
+
+// The collector's own health, as opposed to the modem's: how long logging in
+// and scraping take and how often they fail, how the sinks are doing, and when
+// each router was last collected from successfully. Without this, a gap in the
+// data could mean the line was down or the collector was broken, and there's
+// no way to tell which.
+
+import (
+	"actiontec"
+	"errors"
+	"fmt"
+	"sink"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Counts and timings for one kind of operation. Last is the duration of the
+// most recent attempt; Total is the sum over every attempt, for averaging.
+type Timing struct {
+	Count    uint64
+	Failures uint64
+	Last     time.Duration
+	Total    time.Duration
+}
+
+func (t *Timing) observe(d time.Duration, err error) {
+	t.Count++
+	if err != nil {
+		t.Failures++
+	}
+	t.Last = d
+	t.Total += d
+}
+
+type RouterStats struct {
+	// How often the router is meant to be collected from, if we know.
+	Interval time.Duration
+
+	Login  Timing
+	Scrape Timing
+
+	// Payloads that the router sent, but ParseStatus couldn't make sense of.
+	// These are counted as scrape failures too.
+	ParseErrors uint64
+
+	// The last time a complete sample was collected, or the zero time if that
+	// hasn't happened yet, along with what was collected. These are shared, so
+	// must not be modified.
+	LastSuccess time.Time
+	Status      *actiontec.Status
+	Lines       []actiontec.LineStats
+}
+
+type SinkStats struct {
+	Send Timing
+
+	// The number of batches waiting to be delivered, for sinks with a spool.
+	// -1 if the sink doesn't have one.
+	SpoolDepth int
+
+	// The last time a send succeeded, or the zero time if none has yet.
+	LastSuccess time.Time
+}
+
+// Keeps track of everything. The zero value isn't usable; use New. All
+// methods are safe for concurrent use, and do nothing on a nil Monitor, so
+// that anything that doesn't care about health can pass nil.
+type Monitor struct {
+	mu      sync.Mutex
+	routers map[string]*RouterStats
+	sinks   map[string]*sinkEntry
+}
+
+type sinkEntry struct {
+	stats SinkStats
+	spool func() int
+}
+
+func New() *Monitor {
+	return &Monitor{
+		routers: make(map[string]*RouterStats),
+		sinks:   make(map[string]*sinkEntry),
+	}
+}
+
+// Records a call to Context.Login.
+func (m *Monitor) ObserveLogin(router string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.router(router).Login.observe(d, err)
+}
+
+// Records a call to Context.GetStatus. Parse errors are picked out of err.
+func (m *Monitor) ObserveScrape(router string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs := m.router(router)
+	rs.Scrape.observe(d, err)
+
+	var perr *actiontec.ParseError
+	if errors.As(err, &perr) {
+		rs.ParseErrors++
+	}
+}
+
+// Records a complete, successful collection.
+func (m *Monitor) ObserveSample(sample *sink.Sample) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs := m.router(sample.Router)
+	rs.LastSuccess = sample.Time
+	rs.Status = sample.Status
+	rs.Lines = sample.Lines
+}
+
+// Records a send (of a sample or an event) to a sink.
+func (m *Monitor) ObserveSend(sink string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.sink(sink)
+	e.stats.Send.observe(d, err)
+	if err == nil {
+		e.stats.LastSuccess = time.Now()
+	}
+}
+
+// Registers a function that returns the depth of a sink's spool.
+func (m *Monitor) WatchSpool(sink string, depth func() int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sink(sink).spool = depth
+}
+
+// Sets the routers being collected from, and their intervals. Any others are
+// forgotten, so that a router that's been removed from the configuration
+// doesn't stop us being ready forever.
+func (m *Monitor) SetRouters(intervals map[string]time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name := range m.routers {
+		if _, ok := intervals[name]; !ok {
+			delete(m.routers, name)
+		}
+	}
+	for name, interval := range intervals {
+		m.router(name).Interval = interval
+	}
+}
+
+// As SetRouters, but for sinks.
+func (m *Monitor) SetSinks(names []string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+		m.sink(name)
+	}
+	for name := range m.sinks {
+		if !keep[name] {
+			delete(m.sinks, name)
+		}
+	}
+}
+
+// A copy of everything, keyed by router or sink name.
+type Snapshot struct {
+	Routers map[string]RouterStats
+	Sinks   map[string]SinkStats
+}
+
+func (m *Monitor) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Routers: make(map[string]RouterStats),
+		Sinks:   make(map[string]SinkStats),
+	}
+	if m == nil {
+		return s
+	}
+
+	// Spools hold their own lock while they're draining, which can take as long
+	// as the network does, so they're only asked for their depth once we've let
+	// go of ours. Otherwise a slow drain would hold up every collection loop.
+	spools := make(map[string]func() int)
+
+	m.mu.Lock()
+	for name, rs := range m.routers {
+		s.Routers[name] = *rs
+	}
+
+	for name, e := range m.sinks {
+		stats := e.stats
+		stats.SpoolDepth = -1
+		if e.spool != nil {
+			spools[name] = e.spool
+		}
+		s.Sinks[name] = stats
+	}
+	m.mu.Unlock()
+
+	for name, spool := range spools {
+		stats := s.Sinks[name]
+		stats.SpoolDepth = spool()
+		s.Sinks[name] = stats
+	}
+
+	return s
+}
+
+// The router names in the snapshot, sorted.
+func (s *Snapshot) RouterNames() []string {
+	names := make([]string, 0, len(s.Routers))
+	for name := range s.Routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// The sink names in the snapshot, sorted.
+func (s *Snapshot) SinkNames() []string {
+	names := make([]string, 0, len(s.Sinks))
+	for name := range s.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Returns the reasons we aren't ready, or nothing if we are. Every router must
+// have been collected from within the last intervals of its collection
+// intervals. Every sink that's been sent anything must have had a successful
+// send within the same number of the shortest interval; sinks that haven't
+// been sent anything yet are ignored, since that's the routers' fault.
+func (s *Snapshot) NotReady(now time.Time, intervals int) []string {
+	if len(s.Routers) == 0 {
+		return []string{"no routers are configured"}
+	}
+
+	var reasons []string
+	var shortest time.Duration
+	for _, name := range s.RouterNames() {
+		rs := s.Routers[name]
+		window := time.Duration(intervals) * rs.Interval
+
+		if rs.LastSuccess.IsZero() {
+			reasons = append(reasons, fmt.Sprintf("router %s: not collected from yet", name))
+		} else if now.Sub(rs.LastSuccess) > window {
+			reasons = append(reasons, fmt.Sprintf("router %s: not collected from since %v", name, rs.LastSuccess.Format(time.RFC3339)))
+		}
+
+		if shortest == 0 || rs.Interval < shortest {
+			shortest = rs.Interval
+		}
+	}
+
+	window := time.Duration(intervals) * shortest
+	for _, name := range s.SinkNames() {
+		ss := s.Sinks[name]
+
+		if ss.Send.Count == 0 {
+			continue
+		} else if ss.LastSuccess.IsZero() {
+			reasons = append(reasons, fmt.Sprintf("sink %s: no successful sends yet", name))
+		} else if now.Sub(ss.LastSuccess) > window {
+			reasons = append(reasons, fmt.Sprintf("sink %s: no successful sends since %v", name, ss.LastSuccess.Format(time.RFC3339)))
+		}
+	}
+
+	return reasons
+}
+
+// Must be called with the lock held.
+func (m *Monitor) router(name string) *RouterStats {
+	rs, ok := m.routers[name]
+	if !ok {
+		rs = new(RouterStats)
+		m.routers[name] = rs
+	}
+
+	return rs
+}
+
+// Must be called with the lock held.
+func (m *Monitor) sink(name string) *sinkEntry {
+	e, ok := m.sinks[name]
+	if !ok {
+		e = new(sinkEntry)
+		m.sinks[name] = e
+	}
+
+	return e
+}
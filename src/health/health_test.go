@@ -0,0 +1,256 @@
+package health
+
+import (
+	"actiontec"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sink"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	m := New()
+	now := time.Unix(1500000000, 0)
+
+	m.ObserveLogin("home", time.Second, nil)
+	m.ObserveLogin("home", 3*time.Second, actiontec.ErrBadCredentials)
+	m.ObserveScrape("home", time.Second, fmt.Errorf("Error getting stats: %w", &actiontec.ParseError{Line: 1, Err: errors.New("nope")}))
+	m.ObserveScrape("home", time.Second, errors.New("connection refused"))
+	m.ObserveSample(&sink.Sample{Source: sink.Source{Router: "home"}, Time: now, Status: &actiontec.Status{}})
+	m.ObserveSend("insights", time.Second, nil)
+	m.WatchSpool("insights", func() int { return 4 })
+	m.ObserveSend("influx", time.Second, errors.New("nope"))
+
+	s := m.Snapshot()
+
+	rs := s.Routers["home"]
+	if rs.Login != (Timing{Count: 2, Failures: 1, Last: 3 * time.Second, Total: 4 * time.Second}) {
+		t.Errorf("Invalid login timing: %+v", rs.Login)
+	}
+	if rs.Scrape.Count != 2 || rs.Scrape.Failures != 2 || rs.ParseErrors != 1 {
+		t.Errorf("Invalid scrape stats: %+v", rs)
+	}
+	if !rs.LastSuccess.Equal(now) || rs.Status == nil {
+		t.Errorf("Invalid last success: %v", rs.LastSuccess)
+	}
+
+	if ss := s.Sinks["insights"]; ss.SpoolDepth != 4 || ss.Send.Count != 1 || ss.LastSuccess.IsZero() {
+		t.Errorf("Invalid insights stats: %+v", ss)
+	}
+	if ss := s.Sinks["influx"]; ss.SpoolDepth != -1 || ss.Send.Failures != 1 || !ss.LastSuccess.IsZero() {
+		t.Errorf("Invalid influx stats: %+v", ss)
+	}
+
+	if names := s.SinkNames(); len(names) != 2 || names[0] != "influx" {
+		t.Errorf("Invalid sink names: %v", names)
+	}
+
+	// Nothing should blow up on a nil monitor.
+	var nilMonitor *Monitor
+	nilMonitor.ObserveLogin("home", time.Second, nil)
+	if s := nilMonitor.Snapshot(); len(s.Routers) != 0 {
+		t.Errorf("Expected an empty snapshot; got %+v", s)
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Send(*sink.Sample) error     { return errors.New("nope") }
+func (failingSink) SendEvent(*sink.Event) error { return nil }
+
+func TestInstrument(t *testing.T) {
+	m := New()
+	s := Instrument(m, "test", failingSink{})
+
+	if err := s.Send(&sink.Sample{}); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+	if err := s.SendEvent(&sink.Event{}); err != nil {
+		t.Errorf("Got an error when one wasn't expected")
+	}
+
+	if stats := m.Snapshot().Sinks["test"]; stats.Send.Count != 2 || stats.Send.Failures != 1 {
+		t.Errorf("Invalid stats: %+v", stats)
+	}
+}
+
+func TestRouterEvent(t *testing.T) {
+	now := time.Unix(1500000060, 0)
+	stats := &RouterStats{
+		Login:       Timing{Count: 2, Last: 1500 * time.Millisecond},
+		LastSuccess: time.Unix(1500000000, 0),
+	}
+
+	event := RouterEvent(sink.Source{Router: "home"}, stats, now)
+	if event.Type != RouterEventType || event.Router != "home" {
+		t.Errorf("Invalid event: %+v", event)
+	}
+
+	for k, v := range map[string]interface{}{
+		"Logins":              uint64(2),
+		"LoginSeconds":        1.5,
+		"LastSuccess":         int64(1500000000),
+		"SecondsSinceSuccess": 60.0,
+	} {
+		if event.Attributes[k] != v {
+			t.Errorf("Expected %s to be %v; got %v", k, v, event.Attributes[k])
+		}
+	}
+
+	// Never having succeeded shouldn't look like having succeeded in 1970.
+	event = SinkEvent("influx", &SinkStats{SpoolDepth: -1}, now)
+	if _, ok := event.Attributes["LastSuccess"]; ok {
+		t.Errorf("Didn't expect LastSuccess: %v", event.Attributes)
+	}
+	if _, ok := event.Attributes["SpoolDepth"]; ok {
+		t.Errorf("Didn't expect SpoolDepth: %v", event.Attributes)
+	}
+}
+
+// A spool that's busy draining mustn't hold up everything else that wants the
+// monitor.
+func TestSnapshotSlowSpool(t *testing.T) {
+	m := New()
+	draining := make(chan struct{})
+	m.WatchSpool("insights", func() int {
+		<-draining
+		return 1
+	})
+
+	done := make(chan *Snapshot)
+	go func() {
+		done <- m.Snapshot()
+	}()
+
+	observed := make(chan struct{})
+	go func() {
+		m.ObserveSend("influx", time.Second, nil)
+		close(observed)
+	}()
+
+	select {
+	case <-observed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ObserveSend was blocked by a spool")
+	}
+
+	close(draining)
+	if s := <-done; s.Sinks["insights"].SpoolDepth != 1 {
+		t.Errorf("Invalid spool depth: %+v", s.Sinks["insights"])
+	}
+}
+
+func TestNotReady(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	recent := now.Add(-time.Minute)
+	stale := now.Add(-time.Hour)
+
+	successCases := []*Snapshot{
+		{
+			Routers: map[string]RouterStats{"home": {Interval: time.Minute, LastSuccess: recent}},
+			Sinks: map[string]SinkStats{
+				"influx":   {Send: Timing{Count: 1}, LastSuccess: recent},
+				"insights": {},
+			},
+		},
+	}
+
+	for _, c := range successCases {
+		if reasons := c.NotReady(now, 3); len(reasons) != 0 {
+			t.Errorf("Expected to be ready; got %v", reasons)
+		}
+	}
+
+	errorCases := []struct {
+		snapshot *Snapshot
+		reason   string
+	}{
+		{&Snapshot{}, "no routers"},
+		{&Snapshot{Routers: map[string]RouterStats{"home": {Interval: time.Minute}}}, "router home"},
+		{&Snapshot{Routers: map[string]RouterStats{"home": {Interval: time.Minute, LastSuccess: stale}}}, "router home"},
+		{
+			&Snapshot{
+				Routers: map[string]RouterStats{"home": {Interval: time.Minute, LastSuccess: recent}},
+				Sinks:   map[string]SinkStats{"influx": {Send: Timing{Count: 5, Failures: 5}}},
+			},
+			"sink influx",
+		},
+		{
+			&Snapshot{
+				Routers: map[string]RouterStats{"home": {Interval: time.Minute, LastSuccess: recent}},
+				Sinks:   map[string]SinkStats{"influx": {Send: Timing{Count: 5}, LastSuccess: stale}},
+			},
+			"sink influx",
+		},
+	}
+
+	for _, c := range errorCases {
+		reasons := c.snapshot.NotReady(now, 3)
+		if len(reasons) != 1 || !strings.HasPrefix(reasons[0], c.reason) {
+			t.Errorf("Expected a reason starting %q; got %v", c.reason, reasons)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	m := New()
+	m.SetRouters(map[string]time.Duration{"home": time.Minute, "work": time.Minute})
+	m.ObserveSample(&sink.Sample{
+		Source: sink.Source{Router: "home"},
+		Time:   now,
+		Status: &actiontec.Status{SoftwareVersion: "T2200H-31.128L.03"},
+		Lines:  []actiontec.LineStats{{State: actiontec.Up}},
+	})
+
+	h := NewHandler(m, 3)
+	h.now = func() time.Time { return now }
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to be OK; got %d", w.Code)
+	}
+
+	// Work hasn't been collected from yet...
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "router work") {
+		t.Errorf("Expected /readyz to be unavailable because of work; got %d: %s", w.Code, w.Body)
+	}
+
+	// ...but once it's been removed from the configuration, it doesn't matter.
+	m.SetRouters(map[string]time.Duration{"home": time.Minute})
+	if w := get("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("Expected /readyz to be OK; got %d: %s", w.Code, w.Body)
+	}
+
+	w := get("/status")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected /status to be OK; got %d", w.Code)
+	}
+
+	var body struct {
+		Routers map[string]struct {
+			Time   time.Time
+			Status actiontec.Status
+			Lines  []actiontec.LineStats
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Got an error when one wasn't expected")
+	}
+
+	home := body.Routers["home"]
+	if !home.Time.Equal(now) || home.Status.SoftwareVersion != "T2200H-31.128L.03" || len(home.Lines) != 1 || home.Lines[0].State != actiontec.Up {
+		t.Errorf("Invalid status: %+v", home)
+	}
+}
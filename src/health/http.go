@@ -0,0 +1,102 @@
+package health
+
+import (
+	"actiontec"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Serves the endpoints that orchestration (and curious humans) poke at:
+//
+//	/healthz  200 as long as the process is up enough to answer.
+//	/readyz   200 if every router has been collected from and every sink
+//	          written to recently (see Snapshot.NotReady); 503 and the
+//	          reasons why not otherwise.
+//	/status   The most recent status and line stats for each router, as JSON.
+type Handler struct {
+	monitor   *Monitor
+	intervals int
+	mux       *http.ServeMux
+
+	// Only replaced by the tests.
+	now func() time.Time
+}
+
+// Things must have happened within the last intervals collection intervals
+// for us to be ready.
+func NewHandler(m *Monitor, intervals int) *Handler {
+	h := &Handler{
+		monitor:   m,
+		intervals: intervals,
+		mux:       http.NewServeMux(),
+		now:       time.Now,
+	}
+
+	h.mux.HandleFunc("/healthz", h.healthz)
+	h.mux.HandleFunc("/readyz", h.readyz)
+	h.mux.HandleFunc("/status", h.status)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	reasons := h.monitor.Snapshot().NotReady(h.now(), h.intervals)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(reasons) == 0 {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	for _, reason := range reasons {
+		fmt.Fprintln(w, reason)
+	}
+}
+
+// What /status returns for each router. Time and the rest are left out until
+// there's been a successful collection.
+type routerStatus struct {
+	Time   *time.Time            `json:"time,omitempty"`
+	Status *actiontec.Status     `json:"status,omitempty"`
+	Lines  []actiontec.LineStats `json:"lines,omitempty"`
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	snapshot := h.monitor.Snapshot()
+
+	routers := make(map[string]routerStatus, len(snapshot.Routers))
+	for name, rs := range snapshot.Routers {
+		var status routerStatus
+		if !rs.LastSuccess.IsZero() {
+			t := rs.LastSuccess
+			status = routerStatus{Time: &t, Status: rs.Status, Lines: rs.Lines}
+		}
+		routers[name] = status
+	}
+
+	// As with the metrics, encode first so that an error doesn't turn into a
+	// truncated 200.
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(map[string]interface{}{"routers": routers}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buffer.Bytes())
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"io"
+	"sink"
+	"time"
+)
+
+// Wraps a sink so that every send to it is recorded against name. Close is
+// passed through, if the sink has one.
+func Instrument(m *Monitor, name string, s sink.Sink) sink.Sink {
+	return &instrumented{m, name, s}
+}
+
+type instrumented struct {
+	monitor *Monitor
+	name    string
+	sink    sink.Sink
+}
+
+func (i *instrumented) Send(sample *sink.Sample) error {
+	start := time.Now()
+	err := i.sink.Send(sample)
+	i.monitor.ObserveSend(i.name, time.Since(start), err)
+
+	return err
+}
+
+func (i *instrumented) SendEvent(event *sink.Event) error {
+	start := time.Now()
+	err := i.sink.SendEvent(event)
+	i.monitor.ObserveSend(i.name, time.Since(start), err)
+
+	return err
+}
+
+func (i *instrumented) Close() error {
+	if c, ok := i.sink.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// The types of the events below, for sinks that want to treat them specially.
+const (
+	RouterEventType = "CollectorHealth"
+	SinkEventType   = "SinkHealth"
+)
+
+// The event sent periodically for each router, so that the collector's health
+// ends up alongside the modem's.
+func RouterEvent(source sink.Source, stats *RouterStats, now time.Time) *sink.Event {
+	attrs := map[string]interface{}{
+		"Logins":         stats.Login.Count,
+		"LoginFailures":  stats.Login.Failures,
+		"LoginSeconds":   stats.Login.Last.Seconds(),
+		"Scrapes":        stats.Scrape.Count,
+		"ScrapeFailures": stats.Scrape.Failures,
+		"ScrapeSeconds":  stats.Scrape.Last.Seconds(),
+		"ParseErrors":    stats.ParseErrors,
+	}
+	addLastSuccess(attrs, stats.LastSuccess, now)
+
+	return &sink.Event{Source: source, Time: now, Type: RouterEventType, Attributes: attrs}
+}
+
+// The event sent periodically for each sink. These aren't about any router in
+// particular, so they don't have a source.
+func SinkEvent(name string, stats *SinkStats, now time.Time) *sink.Event {
+	attrs := map[string]interface{}{
+		"Sink":         name,
+		"Sends":        stats.Send.Count,
+		"SendFailures": stats.Send.Failures,
+		"SendSeconds":  stats.Send.Last.Seconds(),
+	}
+	if stats.SpoolDepth >= 0 {
+		attrs["SpoolDepth"] = stats.SpoolDepth
+	}
+	addLastSuccess(attrs, stats.LastSuccess, now)
+
+	return &sink.Event{Time: now, Type: SinkEventType, Attributes: attrs}
+}
+
+// LastSuccess is a Unix timestamp, since not every sink can cope with times.
+// Neither attribute is sent until there's been a success.
+func addLastSuccess(attrs map[string]interface{}, t, now time.Time) {
+	if t.IsZero() {
+		return
+	}
+
+	attrs["LastSuccess"] = t.Unix()
+	attrs["SecondsSinceSuccess"] = now.Sub(t).Seconds()
+}
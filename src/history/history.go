@@ -0,0 +1,185 @@
+package history
+
+// Somewhere to keep samples, so that they can be looked at again after they've
+// been shipped off to the sinks: either in memory for a little while (Memory),
+// or on disk with older samples rolled up into hours and days (Store).
+// Samples are flattened into numbers the same way the sinks flatten them into
+// fields, which keeps this from having to know about every field in the
+// actiontec structs, and means the names used to query history are the same
+// ones used everywhere else.
+
+import (
+	"actiontec"
+	"reflect"
+	"sink"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Numeric values keyed by field name, as produced by sink.StatusFields and
+// sink.LineStatsFields. Strings don't survive flattening, except for the
+// line state, which becomes LinkUp: 1 if the line was up and 0 if it wasn't.
+type Values map[string]float64
+
+// Values for the modem as a whole, and for each line, indexed by line number
+// as in sink.Sample.
+type Set struct {
+	Status Values   `json:"status"`
+	Lines  []Values `json:"lines"`
+}
+
+// What's kept of a single sample, or of a period's worth of them. For a
+// rollup, Time is the start of the period, the values are the means over it,
+// and Rollup has the rest.
+type Point struct {
+	Time time.Time `json:"time"`
+	Set
+	Rollup *Rollup `json:"rollup,omitempty"`
+}
+
+type Rollup struct {
+	// The number of samples in the period.
+	Count int `json:"count"`
+	Min   Set `json:"min"`
+	Max   Set `json:"max"`
+}
+
+// Flattens a sample into a point.
+func NewPoint(sample *sink.Sample) Point {
+	p := Point{
+		Time: sample.Time,
+		Set: Set{
+			Status: values(sink.StatusFields(sample.Status)),
+			Lines:  make([]Values, len(sample.Lines)),
+		},
+	}
+
+	for i := range sample.Lines {
+		ls := &sample.Lines[i]
+		p.Lines[i] = values(sink.LineStatsFields(ls))
+
+		if ls.State == actiontec.Up {
+			p.Lines[i]["LinkUp"] = 1
+		} else {
+			p.Lines[i]["LinkUp"] = 0
+		}
+	}
+
+	return p
+}
+
+// Whether name is a field that points have, either for the modem or for each
+// line. Handy for checking names given by people.
+func IsField(name string) bool {
+	p := NewPoint(&sink.Sample{
+		Status: &actiontec.Status{},
+		Lines:  []actiontec.LineStats{{}},
+	})
+
+	_, ok := p.Status[name]
+	if !ok {
+		_, ok = p.Lines[0][name]
+	}
+	return ok
+}
+
+func values(fields []sink.Field) Values {
+	v := make(Values, len(fields))
+	for _, f := range fields {
+		rv := reflect.ValueOf(f.Value)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v[f.Name] = float64(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v[f.Name] = float64(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			v[f.Name] = rv.Float()
+		}
+	}
+
+	return v
+}
+
+// Anything that history can be read back from.
+type Reader interface {
+	// The names of every router there's history for, sorted.
+	Routers() ([]string, error)
+
+	// The points for a router between from and to (inclusive), oldest first.
+	Query(router string, from, to time.Time) ([]Point, error)
+}
+
+// History kept in memory, for as long as the retention period. This is also a
+// sink, so it can be fed like any other output. Nothing survives a restart.
+type Memory struct {
+	retention time.Duration
+
+	mu      sync.RWMutex
+	routers map[string][]Point
+}
+
+func NewMemory(retention time.Duration) *Memory {
+	return &Memory{
+		retention: retention,
+		routers:   make(map[string][]Point),
+	}
+}
+
+func (m *Memory) Send(sample *sink.Sample) error {
+	p := NewPoint(sample)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	points := append(m.routers[sample.Router], p)
+
+	// Samples arrive in order, so everything that's expired is at the front.
+	cutoff := sample.Time.Add(-m.retention)
+	expired := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(cutoff)
+	})
+	m.routers[sample.Router] = points[expired:]
+
+	return nil
+}
+
+// Events aren't kept.
+func (m *Memory) SendEvent(event *sink.Event) error {
+	return nil
+}
+
+func (m *Memory) Routers() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.routers))
+	for name := range m.routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// The points are shared, so they must not be modified.
+func (m *Memory) Query(router string, from, to time.Time) ([]Point, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	points := m.routers[router]
+	start := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(from)
+	})
+	end := sort.Search(len(points), func(i int) bool {
+		return points[i].Time.After(to)
+	})
+	if start >= end {
+		return nil, nil
+	}
+
+	result := make([]Point, end-start)
+	copy(result, points[start:end])
+
+	return result, nil
+}
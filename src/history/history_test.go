@@ -0,0 +1,88 @@
+package history
+
+import (
+	"actiontec"
+	"sink"
+	"testing"
+	"time"
+)
+
+func sample(router string, t time.Time, snr uint64) *sink.Sample {
+	return &sink.Sample{
+		Source: sink.Source{Router: router},
+		Time:   t,
+		Status: &actiontec.Status{TotalRetrains: 3, ChannelType: actiontec.FastChannel},
+		Lines: []actiontec.LineStats{
+			{State: actiontec.Up, SignalNoiseMargin: actiontec.UintPair{Up: 7, Down: snr}, Uptime: time.Hour},
+			{State: actiontec.Down},
+		},
+	}
+}
+
+func TestNewPoint(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	p := NewPoint(sample("home", now, 9))
+
+	if !p.Time.Equal(now) || len(p.Lines) != 2 {
+		t.Fatalf("Invalid point: %+v", p)
+	}
+
+	if p.Status["Retrains"] != 3 {
+		t.Errorf("Invalid status values: %v", p.Status)
+	}
+	if _, ok := p.Status["ChannelType"]; ok {
+		t.Errorf("Didn't expect strings to be kept: %v", p.Status)
+	}
+
+	for k, v := range map[string]float64{
+		"SignalNoiseMarginDown": 9,
+		"SignalNoiseMarginUp":   7,
+		"Uptime":                3600,
+		"LinkUp":                1,
+	} {
+		if p.Lines[0][k] != v {
+			t.Errorf("Expected %s to be %v; got %v", k, v, p.Lines[0][k])
+		}
+	}
+	if p.Lines[1]["LinkUp"] != 0 {
+		t.Errorf("Expected line 1 to be down: %v", p.Lines[1])
+	}
+}
+
+func TestMemory(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	m := NewMemory(time.Hour)
+
+	for i := 0; i < 120; i++ {
+		if err := m.Send(sample("home", start.Add(time.Duration(i)*time.Minute), uint64(i))); err != nil {
+			t.Errorf("Got an error when one wasn't expected")
+		}
+	}
+	m.Send(sample("work", start, 1))
+
+	if routers, _ := m.Routers(); len(routers) != 2 || routers[0] != "home" {
+		t.Errorf("Invalid routers: %v", routers)
+	}
+
+	// Only the last hour should have been kept.
+	points, err := m.Query("home", start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected")
+	}
+	if len(points) != 61 || points[0].Lines[0]["SignalNoiseMarginDown"] != 59 {
+		t.Errorf("Expected the last 61 points; got %d starting with %v", len(points), points[0].Lines[0])
+	}
+
+	// Both ends are inclusive.
+	points, _ = m.Query("home", start.Add(100*time.Minute), start.Add(110*time.Minute))
+	if len(points) != 11 {
+		t.Errorf("Expected 11 points; got %d", len(points))
+	}
+
+	if points, _ := m.Query("home", start, start.Add(time.Minute)); len(points) != 0 {
+		t.Errorf("Expected no points; got %d", len(points))
+	}
+	if points, _ := m.Query("nowhere", start, start.Add(24*time.Hour)); len(points) != 0 {
+		t.Errorf("Expected no points; got %d", len(points))
+	}
+}
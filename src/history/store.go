@@ -0,0 +1,561 @@
+package history
+
+// History on disk. Each router gets a directory, with a directory inside it
+// for each resolution, and the points are appended to JSON lines files
+// covering a day (raw), a month (hourly) or a year (daily). That keeps any one
+// file small enough to read in full, makes expiring old data a matter of
+// deleting files, and leaves everything readable with a text editor.
+//
+// Rollups are calculated from what's on disk once the period they cover is
+// over, rather than accumulated in memory, so that a restart doesn't lose the
+// hour (or day) in progress. Hours are rolled up from the raw samples, and days
+// from the hours.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sink"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Resolution int
+
+const (
+	Raw Resolution = iota
+	Hourly
+	Daily
+)
+
+var resolutionNames = []string{"raw", "hourly", "daily"}
+
+func (r Resolution) String() string {
+	if r >= Raw && r <= Daily {
+		return resolutionNames[r]
+	}
+
+	return fmt.Sprintf("Resolution(%d)", int(r))
+}
+
+func ParseResolution(s string) (Resolution, error) {
+	for i, name := range resolutionNames {
+		if s == name {
+			return Resolution(i), nil
+		}
+	}
+
+	return Raw, fmt.Errorf("Unknown resolution: %s", s)
+}
+
+// The length of the period each point covers. Raw samples are as often as the
+// router is collected from, which is usually every minute.
+func (r Resolution) Period() time.Duration {
+	switch r {
+	case Hourly:
+		return time.Hour
+	case Daily:
+		return 24 * time.Hour
+	}
+
+	return time.Minute
+}
+
+// How long to keep each resolution for.
+type Retention struct {
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+func (r Retention) of(res Resolution) time.Duration {
+	switch res {
+	case Hourly:
+		return r.Hourly
+	case Daily:
+		return r.Daily
+	}
+
+	return r.Raw
+}
+
+// Queries that don't ask for a resolution get the finest one that has data
+// going back far enough, and doesn't return more than this many points per
+// router.
+const maxPoints = 2500
+
+// History on disk. This is a sink, so it can be fed like any other output, and
+// a Reader. It's safe for concurrent use, but only one Store should use a
+// directory at a time.
+type Store struct {
+	dir       string
+	retention Retention
+
+	mu sync.Mutex
+
+	// The hour each router's rollups and expiry were last brought up to date
+	// for, so that it's only done once an hour.
+	maintained map[string]time.Time
+
+	// Only replaced by the tests.
+	now func() time.Time
+}
+
+// Opens (or creates) a store in dir.
+func Open(dir string, retention Retention) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		dir:        dir,
+		retention:  retention,
+		maintained: make(map[string]time.Time),
+		now:        time.Now,
+	}, nil
+}
+
+// Records a sample. If it's the first one in a new hour, any hours or days
+// that are now complete are rolled up, and anything that's past its retention
+// is deleted. The sample's time is used for all of that, rather than the
+// clock, so that replayed captures are rolled up properly.
+func (s *Store) Send(sample *sink.Sample) error {
+	p := NewPoint(sample)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(sample.Router, Raw, p); err != nil {
+		return err
+	}
+
+	hour := sample.Time.Truncate(time.Hour)
+	if !hour.After(s.maintained[sample.Router]) {
+		return nil
+	}
+	s.maintained[sample.Router] = hour
+
+	if err := s.rollup(sample.Router, Raw, Hourly, sample.Time); err != nil {
+		return err
+	}
+	if err := s.rollup(sample.Router, Hourly, Daily, sample.Time); err != nil {
+		return err
+	}
+
+	return s.expire(sample.Router, sample.Time)
+}
+
+// Events aren't kept.
+func (s *Store) SendEvent(event *sink.Event) error {
+	return nil
+}
+
+func (s *Store) Routers() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if name, err := url.PathUnescape(e.Name()); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Returns the points between from and to, inclusive, at the finest resolution
+// that still has data from the start of the range without returning too many
+// points. Use QueryResolution to pick the resolution yourself.
+func (s *Store) Query(router string, from, to time.Time) ([]Point, error) {
+	return s.QueryResolution(router, s.Resolution(from, to), from, to)
+}
+
+// The resolution Query would use for the range.
+func (s *Store) Resolution(from, to time.Time) Resolution {
+	age := s.now().Sub(from)
+	for _, res := range []Resolution{Raw, Hourly} {
+		if age <= s.retention.of(res) && to.Sub(from)/res.Period() <= maxPoints {
+			return res
+		}
+	}
+
+	return Daily
+}
+
+func (s *Store) QueryResolution(router string, res Resolution, from, to time.Time) ([]Point, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(router, res, from, to)
+}
+
+// Where a router's points for a resolution live.
+func (s *Store) path(router string, res Resolution) string {
+	return filepath.Join(s.dir, url.PathEscape(router), res.String())
+}
+
+// Files are named for the start of the period they cover, in UTC.
+var segmentLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+func segmentStart(res Resolution, t time.Time) time.Time {
+	t = t.UTC()
+	switch res {
+	case Hourly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case Daily:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func segmentEnd(res Resolution, start time.Time) time.Time {
+	switch res {
+	case Hourly:
+		return start.AddDate(0, 1, 0)
+	case Daily:
+		return start.AddDate(1, 0, 0)
+	}
+
+	return start.AddDate(0, 0, 1)
+}
+
+// The segments that exist for a router and resolution, by start time, oldest
+// first. Anything in the directory that doesn't look like a segment is
+// ignored.
+func (s *Store) segments(router string, res Resolution) ([]time.Time, error) {
+	entries, err := ioutil.ReadDir(s.path(router, res))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var starts []time.Time
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".jsonl")
+		if name == e.Name() {
+			continue
+		}
+		if t, err := time.Parse(segmentLayouts[res], name); err == nil {
+			starts = append(starts, t)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	return starts, nil
+}
+
+func (s *Store) segmentPath(router string, res Resolution, start time.Time) string {
+	return filepath.Join(s.path(router, res), start.Format(segmentLayouts[res])+".jsonl")
+}
+
+// Must be called with the lock held.
+func (s *Store) append(router string, res Resolution, points ...Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(s.path(router, res), 0755); err != nil {
+		return err
+	}
+
+	for len(points) > 0 {
+		// Write everything that's in the same segment as the first point in one
+		// go.
+		start := segmentStart(res, points[0].Time)
+		n := 1
+		for n < len(points) && segmentStart(res, points[n].Time).Equal(start) {
+			n++
+		}
+
+		if err := s.appendSegment(s.segmentPath(router, res, start), points[:n]); err != nil {
+			return err
+		}
+		points = points[n:]
+	}
+
+	return nil
+}
+
+func (s *Store) appendSegment(path string, points []Point) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	// If the last write was cut short, finish its line off, so that only it is
+	// lost rather than this one too.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			w.WriteByte('\n')
+		}
+	}
+	encoder := json.NewEncoder(w)
+	for _, p := range points {
+		if err := encoder.Encode(p); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Reads the points between from and to, inclusive. Lines that can't be
+// decoded are skipped: the only way to get one is for the process to die part
+// way through writing it, and the rest of the file is still good.
+//
+// Must be called with the lock held.
+func (s *Store) read(router string, res Resolution, from, to time.Time) ([]Point, error) {
+	starts, err := s.segments(router, res)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []Point
+	for _, start := range starts {
+		if !segmentEnd(res, start).After(from) || start.After(to) {
+			continue
+		}
+
+		f, err := os.Open(s.segmentPath(router, res, start))
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			var p Point
+			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+				continue
+			}
+			if !p.Time.Before(from) && !p.Time.After(to) {
+				points = append(points, p)
+			}
+		}
+
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Samples are appended as they arrive, which is almost always in order,
+	// but a replayed capture could put them anywhere.
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	return points, nil
+}
+
+// The time of the newest point at a resolution, or the zero time if there
+// aren't any.
+//
+// Must be called with the lock held.
+func (s *Store) newest(router string, res Resolution) (time.Time, error) {
+	starts, err := s.segments(router, res)
+	if err != nil || len(starts) == 0 {
+		return time.Time{}, err
+	}
+
+	// The newest segment could (just about) be empty, if a write failed.
+	for i := len(starts) - 1; i >= 0; i-- {
+		points, err := s.read(router, res, starts[i], segmentEnd(res, starts[i]))
+		if err != nil {
+			return time.Time{}, err
+		}
+		if len(points) > 0 {
+			return points[len(points)-1].Time, nil
+		}
+	}
+
+	return time.Time{}, nil
+}
+
+// Rolls up every complete period at the to resolution that hasn't been rolled
+// up yet, from the points at the from resolution.
+//
+// Must be called with the lock held.
+func (s *Store) rollup(router string, from, to Resolution, now time.Time) error {
+	period := to.Period()
+	end := now.Truncate(period)
+
+	newest, err := s.newest(router, to)
+	if err != nil {
+		return err
+	}
+
+	var start time.Time
+	if !newest.IsZero() {
+		start = newest.Add(period)
+	}
+	if !start.Before(end) {
+		return nil
+	}
+
+	points, err := s.read(router, from, start, end.Add(-1))
+	if err != nil {
+		return err
+	}
+
+	var rollups []Point
+	var agg *aggregate
+	for _, p := range points {
+		t := p.Time.Truncate(period)
+		if agg == nil || !t.Equal(agg.time) {
+			if agg != nil {
+				rollups = append(rollups, agg.point())
+			}
+			agg = newAggregate(t)
+		}
+		agg.add(p)
+	}
+	if agg != nil {
+		rollups = append(rollups, agg.point())
+	}
+
+	return s.append(router, to, rollups...)
+}
+
+// Deletes every segment that's entirely older than its resolution's
+// retention.
+//
+// Must be called with the lock held.
+func (s *Store) expire(router string, now time.Time) error {
+	for _, res := range []Resolution{Raw, Hourly, Daily} {
+		cutoff := now.Add(-s.retention.of(res))
+
+		starts, err := s.segments(router, res)
+		if err != nil {
+			return err
+		}
+
+		for _, start := range starts {
+			if segmentEnd(res, start).After(cutoff) {
+				break
+			}
+			if err := os.Remove(s.segmentPath(router, res, start)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Accumulates points (raw or rolled up already) into a rollup.
+type aggregate struct {
+	time   time.Time
+	count  int
+	status *valuesAggregate
+	lines  []*valuesAggregate
+}
+
+func newAggregate(t time.Time) *aggregate {
+	return &aggregate{time: t, status: newValuesAggregate()}
+}
+
+func (a *aggregate) add(p Point) {
+	n, min, max := 1, p.Set, p.Set
+	if p.Rollup != nil {
+		n, min, max = p.Rollup.Count, p.Rollup.Min, p.Rollup.Max
+	}
+
+	a.count += n
+	a.status.add(p.Status, min.Status, max.Status, n)
+	for i := range p.Lines {
+		for len(a.lines) <= i {
+			a.lines = append(a.lines, newValuesAggregate())
+		}
+
+		var lmin, lmax Values
+		if i < len(min.Lines) {
+			lmin = min.Lines[i]
+		}
+		if i < len(max.Lines) {
+			lmax = max.Lines[i]
+		}
+		a.lines[i].add(p.Lines[i], lmin, lmax, n)
+	}
+}
+
+func (a *aggregate) point() Point {
+	p := Point{Time: a.time, Rollup: &Rollup{Count: a.count}}
+
+	p.Status, p.Rollup.Min.Status, p.Rollup.Max.Status = a.status.values()
+	for _, l := range a.lines {
+		mean, min, max := l.values()
+		p.Lines = append(p.Lines, mean)
+		p.Rollup.Min.Lines = append(p.Rollup.Min.Lines, min)
+		p.Rollup.Max.Lines = append(p.Rollup.Max.Lines, max)
+	}
+
+	return p
+}
+
+// Means are weighted by the number of samples behind each value, so that
+// rolling up rollups gives the same answer as rolling up the samples.
+type valuesAggregate struct {
+	sum, min, max Values
+	count         map[string]int
+}
+
+func newValuesAggregate() *valuesAggregate {
+	return &valuesAggregate{
+		sum:   make(Values),
+		min:   make(Values),
+		max:   make(Values),
+		count: make(map[string]int),
+	}
+}
+
+func (a *valuesAggregate) add(mean, min, max Values, n int) {
+	for k, v := range mean {
+		lo, hi := v, v
+		if x, ok := min[k]; ok {
+			lo = x
+		}
+		if x, ok := max[k]; ok {
+			hi = x
+		}
+
+		if a.count[k] == 0 {
+			a.min[k], a.max[k] = lo, hi
+		} else {
+			a.min[k], a.max[k] = math.Min(a.min[k], lo), math.Max(a.max[k], hi)
+		}
+		a.sum[k] += v * float64(n)
+		a.count[k] += n
+	}
+}
+
+func (a *valuesAggregate) values() (mean, min, max Values) {
+	mean = make(Values, len(a.sum))
+	for k, sum := range a.sum {
+		mean[k] = sum / float64(a.count[k])
+	}
+
+	return mean, a.min, a.max
+}
@@ -0,0 +1,133 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	retention := Retention{Raw: 48 * time.Hour, Hourly: 30 * 24 * time.Hour, Daily: 365 * 24 * time.Hour}
+	s, err := Open(dir, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three days of samples, a minute apart, with the SNR margin going from 0
+	// to 59 every hour.
+	start := time.Date(2017, 7, 14, 0, 0, 0, 0, time.UTC)
+	end := start.Add(72 * time.Hour)
+	for now := start; now.Before(end); now = now.Add(time.Minute) {
+		if err := s.Send(sample("home:8080", now, uint64(now.Minute()))); err != nil {
+			t.Fatalf("Got an error when one wasn't expected: %v", err)
+		}
+	}
+	s.now = func() time.Time { return end }
+
+	if routers, err := s.Routers(); err != nil || len(routers) != 1 || routers[0] != "home:8080" {
+		t.Errorf("Invalid routers: %v, %v", routers, err)
+	}
+
+	// Raw samples are expired a day at a time, so nothing's old enough yet.
+	points, err := s.QueryResolution("home:8080", Raw, start, end)
+	if err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+	if len(points) != 3*24*60 {
+		t.Errorf("Expected three days of raw samples; got %d", len(points))
+	}
+
+	// The last hour is still in progress, so there's no rollup for it yet.
+	points, _ = s.QueryResolution("home:8080", Hourly, start, end)
+	if len(points) != 71 {
+		t.Fatalf("Expected 71 hourly points; got %d", len(points))
+	}
+
+	p := points[0]
+	if !p.Time.Equal(start) || p.Rollup == nil || p.Rollup.Count != 60 {
+		t.Fatalf("Invalid hourly point: %+v", p)
+	}
+	if p.Lines[0]["SignalNoiseMarginDown"] != 29.5 || p.Rollup.Min.Lines[0]["SignalNoiseMarginDown"] != 0 || p.Rollup.Max.Lines[0]["SignalNoiseMarginDown"] != 59 {
+		t.Errorf("Invalid hourly SNR margin: %v/%v/%v", p.Rollup.Min.Lines[0]["SignalNoiseMarginDown"], p.Lines[0]["SignalNoiseMarginDown"], p.Rollup.Max.Lines[0]["SignalNoiseMarginDown"])
+	}
+	if p.Lines[1]["LinkUp"] != 0 || p.Status["Retrains"] != 3 {
+		t.Errorf("Invalid hourly values: %+v", p.Set)
+	}
+
+	// Days are rolled up from the hours, and should come out the same.
+	points, _ = s.QueryResolution("home:8080", Daily, start, end)
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 daily points; got %d", len(points))
+	}
+	if p := points[1]; p.Rollup.Count != 24*60 || p.Lines[0]["SignalNoiseMarginDown"] != 29.5 || p.Rollup.Max.Lines[0]["SignalNoiseMarginDown"] != 59 {
+		t.Errorf("Invalid daily point: %+v", p)
+	}
+
+	// A new store on the same directory should pick up where this one left off,
+	// without rolling anything up twice.
+	s, _ = Open(dir, retention)
+	s.Send(sample("home:8080", end, 0))
+	if points, _ := s.QueryResolution("home:8080", Hourly, start, end); len(points) != 72 {
+		t.Errorf("Expected 72 hourly points; got %d", len(points))
+	}
+	if points, _ := s.QueryResolution("home:8080", Daily, start, end); len(points) != 3 {
+		t.Errorf("Expected 3 daily points; got %d", len(points))
+	}
+
+	// Now the first day of raw samples is entirely outside the retention, it
+	// should be gone, but its rollups should still be there.
+	points, _ = s.QueryResolution("home:8080", Raw, start, end)
+	if len(points) != 2*24*60+1 || !points[0].Time.Equal(start.Add(24*time.Hour)) {
+		t.Errorf("Expected two days of raw samples; got %d from %v", len(points), points[0].Time)
+	}
+
+	// A partly written line shouldn't stop the rest being read.
+	f, _ := os.OpenFile(filepath.Join(dir, "home:8080", "raw", "2017-07-17.jsonl"), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"time": "2017-07-17T00:01:00Z", "sta`)
+	f.Close()
+	s.Send(sample("home:8080", end.Add(2*time.Minute), 0))
+	if points, _ := s.QueryResolution("home:8080", Raw, end, end.Add(time.Hour)); len(points) != 2 {
+		t.Errorf("Expected 2 raw points; got %d", len(points))
+	}
+}
+
+func TestStoreResolution(t *testing.T) {
+	now := time.Date(2017, 7, 14, 0, 0, 0, 0, time.UTC)
+	s := &Store{
+		retention: Retention{Raw: 7 * 24 * time.Hour, Hourly: 90 * 24 * time.Hour, Daily: 3650 * 24 * time.Hour},
+		now:       func() time.Time { return now },
+	}
+
+	cases := []struct {
+		from, to   time.Duration
+		resolution Resolution
+	}{
+		{-24 * time.Hour, 0, Raw},
+		{-7 * 24 * time.Hour, 0, Hourly},
+		{-10 * 24 * time.Hour, -9 * 24 * time.Hour, Hourly},
+		{-365 * 24 * time.Hour, 0, Daily},
+	}
+
+	for _, c := range cases {
+		if res := s.Resolution(now.Add(c.from), now.Add(c.to)); res != c.resolution {
+			t.Errorf("Expected %v for %v to %v; got %v", c.resolution, c.from, c.to, res)
+		}
+	}
+
+	for _, name := range []string{"raw", "hourly", "daily"} {
+		if res, err := ParseResolution(name); err != nil || res.String() != name {
+			t.Errorf("Got an error when one wasn't expected")
+		}
+	}
+	if _, err := ParseResolution("weekly"); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
@@ -0,0 +1,182 @@
+package influx
+
+// Rendering of samples into InfluxDB line protocol:
+// https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/
+//
+// The measurements and field names deliberately mirror the LineStats and
+// ModemStats events sent to Insights, so queries translate fairly directly.
+// Where the data came from (Router, Host, SoftwareVersion and any user defined
+// tags) is sent as tags.
+
+import (
+	"actiontec"
+	"bytes"
+	"fmt"
+	"sink"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A single field value. Line protocol distinguishes integers from floats, so
+// we need to as well.
+type field struct {
+	key   string
+	value interface{}
+}
+
+func sampleToLines(sample *sink.Sample) []byte {
+	buffer := new(bytes.Buffer)
+
+	tags := sourceTags(&sample.Source, sample.Status.SoftwareVersion)
+
+	for i, line := range sample.Lines {
+		buffer.WriteString(lineStatsToLine(i, &line, tags, sample.Time))
+	}
+	buffer.WriteString(statusToLine(sample.Status, tags, sample.Time))
+	if sample.Delta != nil {
+		buffer.WriteString(deltaToLine(sample.Delta, tags, sample.Time))
+	}
+
+	return buffer.Bytes()
+}
+
+// Events become a measurement named for the event type, with every attribute
+// as a field. Line protocol requires at least one field, so events without
+// attributes get a dummy one.
+func eventToLine(event *sink.Event) string {
+	keys := make([]string, 0, len(event.Attributes))
+	for k := range event.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]field, 0, len(keys))
+	for _, k := range keys {
+		fields = append(fields, field{k, event.Attributes[k]})
+	}
+
+	if len(fields) == 0 {
+		fields = append(fields, field{"Count", 1})
+	}
+
+	return formatLine(event.Type, sourceTags(&event.Source, ""), fields, event.Time)
+}
+
+// User defined tags are copied first, so they can't override the built in
+// ones.
+func sourceTags(source *sink.Source, firmware string) map[string]string {
+	tags := make(map[string]string, len(source.Tags)+3)
+	for k, v := range source.Tags {
+		tags[k] = v
+	}
+
+	tags["Router"] = source.Router
+	tags["Host"] = source.Host
+	if firmware != "" {
+		tags["SoftwareVersion"] = firmware
+	}
+
+	return tags
+}
+
+func lineStatsToLine(line int, stats *actiontec.LineStats, tags map[string]string, t time.Time) string {
+	// As in sourceTags, the built in tag goes last so a user tag can't
+	// override it.
+	lineTags := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		lineTags[k] = v
+	}
+	lineTags["Line"] = strconv.Itoa(line)
+
+	var fields []field
+	for _, f := range sink.LineStatsFields(stats) {
+		fields = append(fields, field{f.Name, f.Value})
+	}
+
+	return formatLine("LineStats", lineTags, fields, t)
+}
+
+func statusToLine(status *actiontec.Status, tags map[string]string, t time.Time) string {
+	var fields []field
+	for _, f := range sink.StatusFields(status) {
+		fields = append(fields, field{f.Name, f.Value})
+	}
+
+	return formatLine("ModemStats", tags, fields, t)
+}
+
+func deltaToLine(delta *actiontec.Delta, tags map[string]string, t time.Time) string {
+	var fields []field
+	for _, f := range sink.DeltaFields(delta) {
+		fields = append(fields, field{f.Name, f.Value})
+	}
+
+	return formatLine("ModemDeltas", tags, fields, t)
+}
+
+// Builds a single line, including the trailing newline. Tags are sorted, as
+// Influx recommends for performance, and tags with empty values are left out,
+// since Influx rejects them.
+func formatLine(measurement string, tags map[string]string, fields []field, t time.Time) string {
+	buffer := bytes.NewBufferString(measurementEscaper.Replace(measurement))
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if tags[k] != "" {
+			fmt.Fprintf(buffer, ",%s=%s", keyEscaper.Replace(k), keyEscaper.Replace(tags[k]))
+		}
+	}
+
+	for i, f := range fields {
+		if i == 0 {
+			buffer.WriteRune(' ')
+		} else {
+			buffer.WriteRune(',')
+		}
+		fmt.Fprintf(buffer, "%s=%s", keyEscaper.Replace(f.key), formatValue(f.value))
+	}
+
+	if !t.IsZero() {
+		fmt.Fprintf(buffer, " %d", t.UnixNano())
+	}
+	buffer.WriteRune('\n')
+
+	return buffer.String()
+}
+
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case uint64:
+		// Influx 1.x integers are signed 64 bit, which is more than enough for
+		// anything the modem is going to report.
+		return strconv.FormatUint(value, 10) + "i"
+	case int:
+		return strconv.Itoa(value) + "i"
+	case uint:
+		return strconv.FormatUint(uint64(value), 10) + "i"
+	case int64:
+		return strconv.FormatInt(value, 10) + "i"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	case bool:
+		return strconv.FormatBool(value)
+	case string:
+		return `"` + stringEscaper.Replace(value) + `"`
+	}
+
+	// Anything else gets stringified, which is better than losing it.
+	return `"` + stringEscaper.Replace(fmt.Sprint(v)) + `"`
+}
+
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var keyEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+var stringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
@@ -0,0 +1,104 @@
+package influx
+
+import (
+	"actiontec"
+	"sink"
+	"testing"
+	"time"
+)
+
+func TestFormatLine(t *testing.T) {
+	ts := time.Unix(1500000000, 0)
+
+	cases := []struct {
+		measurement string
+		tags        map[string]string
+		fields      []field
+		t           time.Time
+		expected    string
+	}{
+		{
+			"ModemStats",
+			nil,
+			[]field{{"RateUp", uint64(2000)}, {"RateDown", uint64(10000)}},
+			ts,
+			"ModemStats RateUp=2000i,RateDown=10000i 1500000000000000000\n",
+		},
+		{
+			"LineStats",
+			map[string]string{"Line": "1", "Host": "a router", "Empty": ""},
+			[]field{{"AttenuationUp", 13.1}},
+			time.Time{},
+			"LineStats,Host=a\\ router,Line=1 AttenuationUp=13.1\n",
+		},
+		{
+			"Odd measurement,name",
+			nil,
+			[]field{{"a=b", `say "hi"`}, {"ok", true}},
+			time.Time{},
+			"Odd\\ measurement\\,name a\\=b=\"say \\\"hi\\\"\",ok=true\n",
+		},
+	}
+
+	for _, c := range cases {
+		line := formatLine(c.measurement, c.tags, c.fields, c.t)
+
+		if line != c.expected {
+			t.Errorf("Invalid line: got %q; expected %q", line, c.expected)
+		}
+	}
+}
+
+func TestLineStatsToLine(t *testing.T) {
+	stats := &actiontec.LineStats{
+		State:             actiontec.Up,
+		Rates:             actiontec.Rates{Up: 2000, Down: 10000},
+		SignalNoiseMargin: actiontec.UintPair{Up: 7, Down: 9},
+		Attenuation:       actiontec.FloatPair{Up: 13.1, Down: 26.6},
+		Retrains:          2,
+		Uptime:            time.Duration(90) * time.Second,
+	}
+
+	tags := map[string]string{"Router": "home", "site": "here"}
+	expected := "LineStats,Line=0,Router=home,site=here State=\"Up\",RateUp=2000i,RateDown=10000i,SignalNoiseMarginUp=7i,SignalNoiseMarginDown=9i,AttenuationUp=13.1,AttenuationDown=26.6,Retrains=2i,Uptime=90i 1500000000000000000\n"
+	if line := lineStatsToLine(0, stats, tags, time.Unix(1500000000, 0)); line != expected {
+		t.Errorf("Invalid line: got %q; expected %q", line, expected)
+	}
+
+	// A user tag can't pretend to be a different line.
+	tags["Line"] = "nope"
+	if line := lineStatsToLine(0, stats, tags, time.Unix(1500000000, 0)); line != expected {
+		t.Errorf("Invalid line: got %q; expected %q", line, expected)
+	}
+}
+
+func TestEventToLine(t *testing.T) {
+	cases := []struct {
+		event    *sink.Event
+		expected string
+	}{
+		{
+			&sink.Event{
+				Source: sink.Source{Router: "home", Host: "192.168.0.1", Tags: map[string]string{"Router": "nope"}},
+				Time:   time.Unix(1500000000, 0),
+				Type:   "RouterUnreachable",
+				Attributes: map[string]interface{}{
+					"Error":     "connection refused",
+					"Permanent": false,
+					"Attempt":   3,
+				},
+			},
+			"RouterUnreachable,Host=192.168.0.1,Router=home Attempt=3i,Error=\"connection refused\",Permanent=false 1500000000000000000\n",
+		},
+		{
+			&sink.Event{Type: "Nothing"},
+			"Nothing Count=1i\n",
+		},
+	}
+
+	for _, c := range cases {
+		if line := eventToLine(c.event); line != c.expected {
+			t.Errorf("Invalid line: got %q; expected %q", line, c.expected)
+		}
+	}
+}
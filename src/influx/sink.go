@@ -0,0 +1,103 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sink"
+	"sync"
+	"time"
+)
+
+// A sink that writes each sample as InfluxDB line protocol, either to the
+// HTTP write API or to an arbitrary io.Writer (a file, or stdout).
+type Sink struct {
+	write func(data []byte) error
+	close func() error
+
+	// Only for HTTP sinks.
+	client *http.Client
+}
+
+// Create a sink that POSTs to the given write URL, which should include the
+// database and any other parameters, eg http://localhost:8086/write?db=modem
+// Requests time out after 30 seconds; call SetTimeout to change that.
+func NewHTTPSink(url string) *Sink {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	return &Sink{
+		client: client,
+		write: func(data []byte) error {
+			resp, err := client.Post(url, "text/plain; charset=utf-8", bytes.NewBuffer(data))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			// Influx returns 204 on success, and a JSON blob with an error field
+			// otherwise. We'll just pass the body along verbatim.
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				body, _ := ioutil.ReadAll(resp.Body)
+				return fmt.Errorf("Unexpected HTTP response code from InfluxDB: %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+			}
+
+			return nil
+		},
+	}
+}
+
+// Set the timeout for each write request, including reading the response.
+// Zero means no timeout. Does nothing for sinks that aren't writing over HTTP.
+func (s *Sink) SetTimeout(timeout time.Duration) {
+	if s.client != nil {
+		s.client.Timeout = timeout
+	}
+}
+
+// Create a sink that writes line protocol to w.
+func NewWriterSink(w io.Writer) *Sink {
+	var mu sync.Mutex
+
+	return &Sink{
+		write: func(data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			_, err := w.Write(data)
+			return err
+		},
+	}
+}
+
+// Create a sink that appends line protocol to the file at path, creating it if
+// necessary. The file is closed by Close.
+func NewFileSink(path string) (*Sink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewWriterSink(f)
+	s.close = f.Close
+	return s, nil
+}
+
+func (s *Sink) Send(sample *sink.Sample) error {
+	return s.write(sampleToLines(sample))
+}
+
+func (s *Sink) SendEvent(event *sink.Event) error {
+	return s.write([]byte(eventToLine(event)))
+}
+
+// Closes the file, for sinks created with NewFileSink. Nothing else buffers
+// anything, so there's nothing to do for them.
+func (s *Sink) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
@@ -2,38 +2,183 @@ package insights
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // New Relic Insights provides a REST API for inserting arbitrary events, in
-// which case you can basically use it as a simple time series database.
-//
-// This function will take a JSON blob in the form that the API expects:
+// which case you can basically use it as a simple time series database:
 // https://docs.newrelic.com/docs/insights/new-relic-insights/adding-querying-data/inserting-custom-events-insights-api
-func Insert(account int, apiKey string, events []byte) error {
-	client := &http.Client{}
 
-	url := fmt.Sprintf("https://insights-collector.newrelic.com/v1/accounts/%d/events", account)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(events))
+// The collector endpoints for each region.
+const (
+	USEndpoint = "https://insights-collector.newrelic.com"
+	EUEndpoint = "https://insights-collector.eu01.nr-data.net"
+)
+
+// Returns the endpoint for a region name ("us" or "eu").
+func RegionEndpoint(region string) (string, error) {
+	switch strings.ToLower(region) {
+	case "", "us":
+		return USEndpoint, nil
+	case "eu":
+		return EUEndpoint, nil
+	}
+
+	return "", fmt.Errorf("Unknown Insights region: %s", region)
+}
+
+// A single event. Type becomes the eventType, and the attributes are merged in
+// alongside it. If Timestamp is zero, Insights uses the time the event arrived.
+type Event struct {
+	Type       string
+	Timestamp  time.Time
+	Attributes map[string]interface{}
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	data := make(map[string]interface{}, len(e.Attributes)+2)
+	for k, v := range e.Attributes {
+		data[k] = v
+	}
+
+	data["eventType"] = e.Type
+	if !e.Timestamp.IsZero() {
+		// Insights accepts seconds or milliseconds; milliseconds keeps more of
+		// the precision.
+		data["timestamp"] = e.Timestamp.UnixNano() / int64(time.Millisecond)
+	}
+
+	return json.Marshal(data)
+}
+
+// A reusable client for the insert API for a single account.
+type Client struct {
+	Account  int
+	APIKey   string
+	Endpoint string
+	Compress bool
+
+	client *http.Client
+}
+
+// Create a client for the US region that gzips payloads and times out after
+// 30 seconds. Change the fields or call SetTimeout to taste.
+func NewClient(account int, apiKey string) *Client {
+	return &Client{
+		Account:  account,
+		APIKey:   apiKey,
+		Endpoint: USEndpoint,
+		Compress: true,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Set the timeout for each insert request, including reading the response.
+// Zero means no timeout.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.client.Timeout = timeout
+}
+
+func (c *Client) Insert(events []Event) error {
+	return c.InsertContext(context.Background(), events)
+}
+
+func (c *Client) InsertContext(ctx context.Context, events []Event) error {
+	data, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	return c.insertRaw(ctx, data)
+}
+
+// Returned when the collector responds with anything other than success.
+type ResponseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Unexpected HTTP response code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Unexpected HTTP response code: %d: %s", e.StatusCode, e.Message)
+}
+
+// Whether sending the same events again could ever work. Client errors mean
+// the collector didn't like the events themselves, except for timeouts and
+// rate limiting.
+func (e *ResponseError) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// The collector's response body, which is the only place the reason for a
+// failure shows up.
+type response struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+func (c *Client) insertRaw(ctx context.Context, events []byte) error {
+	body := bytes.NewBuffer(events)
+	if c.Compress {
+		body = new(bytes.Buffer)
+		gz := gzip.NewWriter(body)
+		if _, err := gz.Write(events); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	url := fmt.Sprintf("%s/v1/accounts/%d/events", strings.TrimRight(c.Endpoint, "/"), c.Account)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Insert-Key", apiKey)
+	req.Header.Set("X-Insert-Key", c.APIKey)
+	if c.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var parsed response
+	jsonErr := json.Unmarshal(data, &parsed)
+
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("Unexpected HTTP response code: %d", resp.StatusCode)
+		return &ResponseError{resp.StatusCode, parsed.Error}
 	}
 
-	// Don't care about the response: if there wasn't an error, it was
-	// successful.
+	// A 200 with success set to false is apparently possible.
+	if jsonErr == nil && !parsed.Success && parsed.Error != "" {
+		return &ResponseError{resp.StatusCode, parsed.Error}
+	}
 
 	return nil
 }
+
+// The original one-shot interface: takes a JSON blob in the form that the API
+// expects and sends it to the US collector. Client is more flexible.
+func Insert(account int, apiKey string, events []byte) error {
+	return NewClient(account, apiKey).insertRaw(context.Background(), events)
+}
@@ -0,0 +1,114 @@
+package insights
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventMarshalJSON(t *testing.T) {
+	cases := []struct {
+		event    Event
+		expected string
+	}{
+		{
+			Event{Type: "ModemStats", Attributes: map[string]interface{}{"RateUp": 1000}},
+			`{"RateUp":1000,"eventType":"ModemStats"}`,
+		},
+		{
+			Event{Type: "LineStats", Timestamp: time.Unix(1500000000, 500000000)},
+			`{"eventType":"LineStats","timestamp":1500000000500}`,
+		},
+	}
+
+	for _, c := range cases {
+		data, err := json.Marshal(c.event)
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected: %v", err)
+		}
+
+		if string(data) != c.expected {
+			t.Errorf("Invalid JSON: got %s; expected %s", data, c.expected)
+		}
+	}
+}
+
+func TestClientInsert(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/accounts/123/events" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+
+		if r.Header.Get("X-Insert-Key") != "key" || r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Unexpected headers: %v", r.Header)
+		}
+
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(gz)
+
+		w.Write([]byte(`{"success":true,"uuid":"abc"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(123, "key")
+	client.Endpoint = server.URL + "/"
+
+	if err := client.Insert([]Event{{Type: "ModemStats"}}); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if string(body) != `[{"eventType":"ModemStats"}]` {
+		t.Errorf("Unexpected body: %s", body)
+	}
+}
+
+func TestClientInsertError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"success":false,"error":"Invalid insert key"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(123, "key")
+	client.Endpoint = server.URL
+
+	err := client.Insert([]Event{{Type: "ModemStats"}})
+	if err == nil || !strings.Contains(err.Error(), "Invalid insert key") {
+		t.Errorf("Expected the collector's error; got %v", err)
+	}
+}
+
+func TestRegionEndpoint(t *testing.T) {
+	cases := []struct {
+		region   string
+		expected string
+	}{
+		{"", USEndpoint},
+		{"us", USEndpoint},
+		{"EU", EUEndpoint},
+	}
+
+	for _, c := range cases {
+		endpoint, err := RegionEndpoint(c.region)
+		if err != nil {
+			t.Errorf("Got an error when one wasn't expected: %v", err)
+		}
+
+		if endpoint != c.expected {
+			t.Errorf("Invalid endpoint: got %s; expected %s", endpoint, c.expected)
+		}
+	}
+
+	if _, err := RegionEndpoint("mars"); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
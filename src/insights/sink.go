@@ -0,0 +1,261 @@
+package insights
+
+import (
+	"actiontec"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sink"
+	"spool"
+	"sync"
+	"time"
+)
+
+// A sink that inserts each sample into Insights as a ModemStats event and one
+// LineStats event per line, plus a ModemDeltas event if the sample has a delta.
+//
+// If Spool is set, batches that can't be inserted are stored there and
+// inserted, in order, once Insights is reachable again. That happens in the
+// background, a few batches at a time, so that working through a long backlog
+// doesn't hold up new samples; until the spool's empty, new batches join the
+// end of it rather than jumping the queue.
+type Sink struct {
+	Client *Client
+	Spool  *spool.Spool
+
+	mu sync.Mutex
+
+	// The background drain, started the first time there's anything to drain.
+	start   sync.Once
+	wake    chan struct{}
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// How many batches the background drain sends before checking whether it's
+// been stopped.
+const drainChunk = 10
+
+// How long Close spends on a last attempt to empty the spool. A variable so
+// that tests don't have to wait that long.
+var closeTimeout = 10 * time.Second
+
+func (s *Sink) Send(sample *sink.Sample) error {
+	events := createEvents(sample.Status, sample.Lines, sample.Delta)
+	identify(events, sample.Time, &sample.Source, sample.Status.SoftwareVersion)
+
+	return s.insert(events)
+}
+
+func (s *Sink) SendEvent(event *sink.Event) error {
+	events := []Event{sinkEventToEvent(event)}
+	identify(events, event.Time, &event.Source, "")
+
+	return s.insert(events)
+}
+
+func (s *Sink) insert(events []Event) error {
+	if s.Spool == nil {
+		return s.Client.Insert(events)
+	}
+
+	// Deciding whether to insert straight away or spool has to happen in order,
+	// or a batch could be inserted ahead of an older one that's being spooled.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The spool's still being drained, so this has to wait its turn. That's not
+	// an error: it'll get there.
+	if s.Spool.Len() > 0 {
+		if err := s.push(events); err != nil {
+			return fmt.Errorf("Error spooling events: %v", err)
+		}
+		s.kick()
+		return nil
+	}
+
+	err := s.Client.Insert(events)
+	if err == nil {
+		return nil
+	}
+	if rerr, ok := err.(*ResponseError); ok && rerr.Permanent() {
+		return err
+	}
+
+	if serr := s.push(events); serr != nil {
+		return fmt.Errorf("%v (and spooling failed: %v)", err, serr)
+	}
+	s.kick()
+
+	return fmt.Errorf("%v (spooled for later; %d batches waiting)", err, s.Spool.Len())
+}
+
+func (s *Sink) push(events []Event) error {
+	data, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	dropped, err := s.Spool.Push(data)
+	if dropped > 0 {
+		log.Printf("Insights spool is full: dropped %d old batches", dropped)
+	}
+	return err
+}
+
+// Nudges the background drain, starting it if need be. Nothing's lost if it's
+// already busy: it keeps going until the spool's empty or an insert fails, and
+// after a failure, the next batch to be spooled wakes it up again.
+func (s *Sink) kick() {
+	s.start.Do(func() {
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(context.Background())
+		s.wake = make(chan struct{}, 1)
+		s.stopped = make(chan struct{})
+
+		go s.drainLoop(ctx)
+	})
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Sink) drainLoop(ctx context.Context) {
+	defer close(s.stopped)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+
+		for ctx.Err() == nil && s.Spool.Len() > 0 {
+			if err := s.drain(ctx, drainChunk); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error inserting spooled events into Insights (will try again later): %v", err)
+				}
+				break
+			}
+		}
+	}
+}
+
+func (s *Sink) drain(ctx context.Context, max int) error {
+	return s.Spool.Drain(max, func(data []byte) error {
+		err := s.Client.insertRaw(ctx, data)
+
+		// A batch that Insights will never accept would block the spool
+		// forever, so it has to go.
+		if rerr, ok := err.(*ResponseError); ok && rerr.Permanent() {
+			log.Printf("Dropping spooled batch rejected by Insights: %v", err)
+			return nil
+		}
+		return err
+	})
+}
+
+// Stops the background drain, and makes a last attempt to insert anything
+// that's spooled, for up to closeTimeout. Whatever can't be inserted stays in
+// the spool, and will be inserted next time.
+func (s *Sink) Close() error {
+	if s.Spool == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+		<-s.stopped
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	if err := s.drain(ctx, 0); err != nil {
+		return fmt.Errorf("%v (%d batches left in the spool for next time)", err, s.Spool.Len())
+	}
+	return nil
+}
+
+// Every event gets the time it was collected and where it came from, so that
+// delayed or spooled events land at the right time and several modems can share
+// an account. Tags are added as attributes, but don't override anything that's
+// already there.
+func identify(events []Event, t time.Time, source *sink.Source, firmware string) {
+	for i := range events {
+		e := &events[i]
+
+		e.Timestamp = t
+		e.Attributes["Router"] = source.Router
+		e.Attributes["Host"] = source.Host
+		if firmware != "" {
+			e.Attributes["SoftwareVersion"] = firmware
+		}
+
+		for k, v := range source.Tags {
+			if _, ok := e.Attributes[k]; !ok {
+				e.Attributes[k] = v
+			}
+		}
+	}
+}
+
+// These functions are a little Insights-specific, although possibly still
+// useful outside that context if you need flat events.
+func createEvents(status *actiontec.Status, lines []actiontec.LineStats, delta *actiontec.Delta) []Event {
+	var events []Event
+
+	for i, line := range lines {
+		events = append(events, lineStatsToEvent(i, &line))
+	}
+
+	if delta != nil {
+		events = append(events, deltaToEvent(delta))
+	}
+
+	return append(events, statusToEvent(status))
+}
+
+func lineStatsToEvent(line int, stats *actiontec.LineStats) Event {
+	attrs := map[string]interface{}{"Line": line}
+	for _, f := range sink.LineStatsFields(stats) {
+		attrs[f.Name] = f.Value
+	}
+
+	return Event{Type: "LineStats", Attributes: attrs}
+}
+
+func statusToEvent(status *actiontec.Status) Event {
+	attrs := make(map[string]interface{})
+	for _, f := range sink.StatusFields(status) {
+		attrs[f.Name] = f.Value
+	}
+
+	return Event{Type: "ModemStats", Attributes: attrs}
+}
+
+func deltaToEvent(delta *actiontec.Delta) Event {
+	attrs := make(map[string]interface{})
+	for _, f := range sink.DeltaFields(delta) {
+		attrs[f.Name] = f.Value
+	}
+
+	return Event{Type: "ModemDeltas", Attributes: attrs}
+}
+
+// The attributes are copied, since identify is going to add to them and the
+// event is shared with every other sink.
+func sinkEventToEvent(event *sink.Event) Event {
+	attrs := make(map[string]interface{}, len(event.Attributes))
+	for k, v := range event.Attributes {
+		attrs[k] = v
+	}
+
+	return Event{Type: event.Type, Attributes: attrs}
+}
@@ -0,0 +1,163 @@
+package insights
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sink"
+	"spool"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSinkSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	up := false
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var events []map[string]interface{}
+		if err := json.NewDecoder(gz).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, events...)
+
+		w.Write([]byte(`{"success":true}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(1, "key")
+	client.Endpoint = server.URL
+
+	s := &Sink{Client: client}
+	if s.Spool, err = spool.Open(dir, 10); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.SendEvent(&sink.Event{Time: time.Unix(1500000000, 0), Type: "Spooled"}); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+
+	// Insights is back, but there's a batch in the spool, so this one queues
+	// up behind it rather than being inserted first.
+	if err := s.SendEvent(&sink.Event{Time: time.Unix(1500000001, 0), Type: "Spooled"}); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); s.Spool.Len() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Spool wasn't drained; %d batches left", s.Spool.Len())
+		}
+	}
+
+	if err := s.SendEvent(&sink.Event{Type: "Live"}); err != nil {
+		t.Errorf("Got an error when one wasn't expected: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received) != 3 {
+		t.Fatalf("Unexpected events: %v", received)
+	}
+
+	for i, expected := range []float64{1500000000000, 1500000001000} {
+		if received[i]["eventType"] != "Spooled" || received[i]["timestamp"] != expected {
+			t.Errorf("Unexpected spooled event: %v", received[i])
+		}
+	}
+
+	if received[2]["eventType"] != "Live" {
+		t.Errorf("Unexpected live event: %v", received[2])
+	}
+}
+
+// Close has a go at emptying the spool, but doesn't wait forever for Insights.
+func TestSinkClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	client := NewClient(1, "key")
+	client.Endpoint = server.URL
+
+	s := &Sink{Client: client}
+	if s.Spool, err = spool.Open(dir, 10); err != nil {
+		t.Fatal(err)
+	}
+	s.push([]Event{{Type: "Spooled", Attributes: map[string]interface{}{}}})
+
+	defer func(timeout time.Duration) { closeTimeout = timeout }(closeTimeout)
+	closeTimeout = 50 * time.Millisecond
+
+	if err := s.Close(); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+	if s.Spool.Len() != 1 {
+		t.Errorf("Expected the batch to stay spooled; got %d batches", s.Spool.Len())
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	events := []Event{{Type: "LineStats", Attributes: map[string]interface{}{"Line": 0}}}
+	source := &sink.Source{
+		Router: "home",
+		Host:   "192.168.0.1",
+		Tags:   map[string]string{"site": "attic", "Line": "nope"},
+	}
+
+	identify(events, now, source, "T2200H-31.128L.03")
+
+	e := events[0]
+	if !e.Timestamp.Equal(now) {
+		t.Errorf("Unexpected timestamp: %v", e.Timestamp)
+	}
+	for k, v := range map[string]interface{}{
+		"Line":            0,
+		"Router":          "home",
+		"Host":            "192.168.0.1",
+		"SoftwareVersion": "T2200H-31.128L.03",
+		"site":            "attic",
+	} {
+		if e.Attributes[k] != v {
+			t.Errorf("Expected %s to be %v; got %v", k, v, e.Attributes[k])
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package prometheus
+
+// A Prometheus exporter for the modem stats. Prometheus is pull based, so
+// rather than pushing samples somewhere, we hold onto the most recent one from
+// each router and serve them up on /metrics in the text exposition format,
+// with a router label to tell them apart.
+//
+// The exporter can be fed in two ways: as a normal sink (in which case it just
+// serves whatever the ticker loop last collected), or by scraping the routers
+// on demand when Prometheus asks. In the latter case, samples are cached for a
+// while, since the Actiontec UI really doesn't appreciate being logged into
+// every few seconds.
+
+import (
+	"bytes"
+	"context"
+	"health"
+	"log"
+	"net/http"
+	"sink"
+	"sync"
+	"time"
+)
+
+// Something that can go and get a fresh sample from the router. The context is
+// cancelled if the scrape goes away.
+type CollectFunc func(ctx context.Context) (*sink.Sample, error)
+
+type Exporter struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	routers map[string]*target
+	events  map[eventKey]uint64
+	monitor *health.Monitor
+}
+
+type target struct {
+	collect CollectFunc
+	sample  *sink.Sample
+
+	// Overrides the exporter's ttl, if set.
+	ttl time.Duration
+
+	// The collection in progress, if there is one, so that scrapes that turn
+	// up while it's happening wait for it rather than logging in again.
+	refreshing *refresh
+}
+
+type refresh struct {
+	done   chan struct{}
+	sample *sink.Sample
+	err    error
+}
+
+type eventKey struct {
+	router string
+	typ    string
+}
+
+// Create an exporter. Routers that should be scraped on demand need to be
+// added with AddRouter; a cached sample older than ttl will be refreshed when
+// /metrics is requested.
+func NewExporter(ttl time.Duration) *Exporter {
+	return &Exporter{
+		ttl:     ttl,
+		routers: make(map[string]*target),
+		events:  make(map[eventKey]uint64),
+	}
+}
+
+// Register a router to be scraped on demand.
+func (e *Exporter) AddRouter(name string, collect CollectFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.target(name).collect = collect
+}
+
+// How long a cached sample is served for, unless SetTTL says otherwise.
+func (e *Exporter) TTL() time.Duration {
+	return e.ttl
+}
+
+// Use a different ttl for a router, such as when it's being collected from on
+// a schedule anyway, so there's no point scraping it more often than that.
+func (e *Exporter) SetTTL(name string, ttl time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.target(name).ttl = ttl
+}
+
+// Serve the collector's own health alongside the modem stats.
+func (e *Exporter) SetMonitor(m *health.Monitor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.monitor = m
+}
+
+// Implements sink.Sink: we just cache the sample until it's scraped.
+func (e *Exporter) Send(sample *sink.Sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.target(sample.Router).sample = sample
+	return nil
+}
+
+// Events don't map onto Prometheus at all well, so we just count them by type.
+// Health events are ignored, since the monitor is served directly.
+func (e *Exporter) SendEvent(event *sink.Event) error {
+	if event.Type == health.RouterEventType || event.Type == health.SinkEventType {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.events[eventKey{event.Router, event.Type}]++
+	return nil
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	samples, events := e.current(r.Context())
+
+	e.mu.Lock()
+	snapshot := e.monitor.Snapshot()
+	e.mu.Unlock()
+
+	// Render to a buffer first so that we can't send a half written response
+	// with a 200.
+	buffer := new(bytes.Buffer)
+	if err := writeMetrics(buffer, samples, events, snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buffer.Bytes())
+}
+
+// Must be called with the lock held.
+func (e *Exporter) target(name string) *target {
+	t, ok := e.routers[name]
+	if !ok {
+		t = new(target)
+		e.routers[name] = t
+	}
+
+	return t
+}
+
+// Returns the samples that should be served, keyed by router name, refreshing
+// them first if they're stale and we're able to, along with a copy of the
+// event counts. If a refresh fails, the router gets a nil sample so that
+// actiontec_up is reported as 0 rather than serving stale data as though it
+// were current.
+//
+// Talking to a router can take a while, so the lock isn't held while that
+// happens: everything else (Send in particular, which the collection loops
+// call) carries on meanwhile.
+func (e *Exporter) current(ctx context.Context) (map[string]*sink.Sample, map[eventKey]uint64) {
+	e.mu.Lock()
+
+	samples := make(map[string]*sink.Sample, len(e.routers))
+	refreshes := make(map[string]*refresh)
+	for name, t := range e.routers {
+		ttl := e.ttl
+		if t.ttl != 0 {
+			ttl = t.ttl
+		}
+
+		if t.collect == nil || (t.sample != nil && time.Since(t.sample.Time) < ttl) {
+			samples[name] = t.sample
+			continue
+		}
+
+		refreshes[name] = e.refresh(ctx, name, t)
+	}
+
+	events := make(map[eventKey]uint64, len(e.events))
+	for k, v := range e.events {
+		events[k] = v
+	}
+
+	e.mu.Unlock()
+
+	for name, r := range refreshes {
+		<-r.done
+		if r.err == nil {
+			samples[name] = r.sample
+		} else {
+			samples[name] = nil
+		}
+	}
+
+	return samples, events
+}
+
+// Starts collecting from a router, unless that's already happening, and
+// returns the collection to wait for. It's done with the context of whichever
+// scrape got there first.
+//
+// Must be called with the lock held.
+func (e *Exporter) refresh(ctx context.Context, name string, t *target) *refresh {
+	if t.refreshing != nil {
+		return t.refreshing
+	}
+
+	r := &refresh{done: make(chan struct{})}
+	t.refreshing = r
+
+	go func() {
+		defer close(r.done)
+
+		r.sample, r.err = t.collect(ctx)
+		if r.err != nil {
+			log.Printf("Error collecting data from %s for Prometheus: %v", name, r.err)
+		}
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		t.refreshing = nil
+
+		// The collection loop may have sent something newer meanwhile.
+		if r.err == nil && (t.sample == nil || !t.sample.Time.After(r.sample.Time)) {
+			t.sample = r.sample
+		}
+	}()
+
+	return r
+}
@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"actiontec"
+	"context"
+	"sink"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Scrapes that arrive while a router is being collected from wait for that
+// collection rather than starting another, and nothing else waits at all.
+func TestExporterRefresh(t *testing.T) {
+	e := NewExporter(time.Minute)
+
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	e.AddRouter("home", func(ctx context.Context) (*sink.Sample, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+
+		<-release
+		return &sink.Sample{Source: sink.Source{Router: "home"}, Time: time.Now(), Status: new(actiontec.Status)}, nil
+	})
+
+	results := make(chan *sink.Sample)
+	for i := 0; i < 2; i++ {
+		go func() {
+			samples, _ := e.current(context.Background())
+			results <- samples["home"]
+		}()
+	}
+
+	// Give both scrapes a chance to get going.
+	time.Sleep(50 * time.Millisecond)
+
+	sent := make(chan error)
+	go func() {
+		sent <- e.Send(&sink.Sample{Source: sink.Source{Router: "office"}, Time: time.Now()})
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send blocked on a collection")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if sample := <-results; sample == nil {
+			t.Errorf("Expected a sample; got none")
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected 1 collection; got %d", calls)
+	}
+
+	// And now it's cached.
+	e.current(context.Background())
+	if calls != 1 {
+		t.Errorf("Expected the sample to be cached; got %d collections", calls)
+	}
+}
+
+func TestExporterTTL(t *testing.T) {
+	e := NewExporter(time.Minute)
+
+	calls := 0
+	e.AddRouter("home", func(ctx context.Context) (*sink.Sample, error) {
+		calls++
+		return &sink.Sample{Source: sink.Source{Router: "home"}, Time: time.Now()}, nil
+	})
+
+	e.Send(&sink.Sample{Source: sink.Source{Router: "home"}, Time: time.Now().Add(-2 * time.Minute)})
+	e.SetTTL("home", 3*time.Minute)
+
+	e.current(context.Background())
+	if calls != 0 {
+		t.Errorf("Expected the sample to still be fresh; got %d collections", calls)
+	}
+
+	e.SetTTL("home", time.Minute)
+	e.current(context.Background())
+	if calls != 1 {
+		t.Errorf("Expected the sample to be refreshed; got %d collections", calls)
+	}
+}
@@ -0,0 +1,345 @@
+package prometheus
+
+// Rendering of samples into the Prometheus text exposition format. This is
+// simple enough that it isn't worth pulling in the official client library.
+
+import (
+	"actiontec"
+	"bufio"
+	"fmt"
+	"health"
+	"io"
+	"regexp"
+	"sink"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type metricType string
+
+const (
+	gauge   metricType = "gauge"
+	counter metricType = "counter"
+)
+
+type labels map[string]string
+
+type value struct {
+	labels labels
+	value  float64
+}
+
+type metric struct {
+	name   string
+	help   string
+	typ    metricType
+	values []value
+}
+
+// Write the metrics for the given samples, keyed by router name, and event
+// counts. A nil sample means we couldn't talk to that router, in which case
+// only actiontec_up is written for it.
+func writeMetrics(w io.Writer, samples map[string]*sink.Sample, events map[eventKey]uint64, snapshot *health.Snapshot) error {
+	bw := bufio.NewWriter(w)
+
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The exposition format wants every value for a metric grouped together,
+	// so merge each router's metrics into one family per name.
+	var families []*metric
+	byName := make(map[string]*metric)
+	add := func(m metric) {
+		family, ok := byName[m.name]
+		if !ok {
+			family = &metric{m.name, m.help, m.typ, nil}
+			byName[m.name] = family
+			families = append(families, family)
+		}
+		family.values = append(family.values, m.values...)
+	}
+
+	for _, name := range names {
+		for _, m := range sampleToMetrics(samples[name]) {
+			for i := range m.values {
+				m.values[i].labels = m.values[i].labels.with("router", name)
+			}
+			add(m)
+		}
+	}
+
+	if len(events) > 0 {
+		add(eventsToMetric(events))
+	}
+
+	for _, m := range healthToMetrics(snapshot) {
+		add(m)
+	}
+
+	for _, m := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.typ)
+		for _, v := range m.values {
+			fmt.Fprintf(bw, "%s%s %s\n", m.name, v.labels, formatFloat(v.value))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func sampleToMetrics(sample *sink.Sample) []metric {
+	if sample == nil {
+		return []metric{
+			{"actiontec_up", "Whether the router could be scraped.", gauge, []value{{nil, 0}}},
+		}
+	}
+
+	status := sample.Status
+	metrics := []metric{
+		{"actiontec_up", "Whether the router could be scraped.", gauge, []value{{nil, 1}}},
+		{
+			"actiontec_modem_info",
+			"Modem information; the value is always 1.",
+			gauge,
+			[]value{{infoLabels(sample), 1}},
+		},
+		{"actiontec_modem_rate_kbps", "Total bonded sync rate in kbps.", gauge, pairValues(nil, float64(status.TotalRate.Up), float64(status.TotalRate.Down))},
+		{"actiontec_modem_retrains_total", "Total retrains across all lines.", counter, []value{{nil, float64(status.TotalRetrains)}}},
+		{
+			"actiontec_modem_link_failures_total",
+			"Link failures by type.",
+			counter,
+			[]value{
+				{labels{"type": "power"}, float64(status.Failures.Power)},
+				{labels{"type": "signal"}, float64(status.Failures.Signal)},
+				{labels{"type": "margin"}, float64(status.Failures.Margin)},
+				{labels{"type": "train"}, float64(status.Failures.Train)},
+			},
+		},
+		{"actiontec_modem_unavailable_seconds_total", "Seconds the link has been unavailable.", counter, []value{{nil, status.UnavailableSeconds.Seconds()}}},
+		{"actiontec_modem_uptime_seconds", "Modem uptime in seconds.", gauge, []value{{nil, status.ModemUptime.Seconds()}}},
+		{
+			"actiontec_modem_packets_total",
+			"Packets by direction.",
+			counter,
+			[]value{
+				{labels{"direction": "received"}, float64(status.Packets.Received.Count)},
+				{labels{"direction": "transmitted"}, float64(status.Packets.Transmitted.Count)},
+			},
+		},
+		{
+			"actiontec_modem_packet_errors_total",
+			"Packet errors by direction.",
+			counter,
+			[]value{
+				{labels{"direction": "received"}, float64(status.Packets.Received.Errors)},
+				{labels{"direction": "transmitted"}, float64(status.Packets.Transmitted.Errors)},
+			},
+		},
+		{"actiontec_modem_crc_errors_total", "CRC errors by end and channel.", counter, endValues(status.Errors.CRC)},
+		{"actiontec_modem_crc_errors_30m", "CRC errors in the current 30 minute window by end and channel.", gauge, endValues(status.Errors.CRC30Minute)},
+		{"actiontec_modem_fec_errors_total", "FEC corrected errors by end and channel.", counter, endValues(status.Errors.FEC)},
+		{"actiontec_modem_fec_errors_30m", "FEC corrected errors in the current 30 minute window by end and channel.", gauge, endValues(status.Errors.FEC30Minute)},
+		{"actiontec_modem_hec_errors_total", "HEC errors by end.", counter, nearFarValues(status.Errors.HEC)},
+		{"actiontec_modem_errored_seconds_total", "Errored seconds by end.", counter, nearFarValues(status.Errors.ErroredSeconds)},
+		{"actiontec_modem_severely_errored_seconds_total", "Severely errored seconds by end.", counter, nearFarValues(status.Errors.SeverelyErroredSeconds)},
+	}
+
+	// Per line metrics. Each metric gets a value per line, so build them up
+	// column-wise.
+	rate := metric{"actiontec_line_rate_kbps", "Line sync rate in kbps.", gauge, nil}
+	snr := metric{"actiontec_line_snr_margin_db", "Line signal to noise margin in dB.", gauge, nil}
+	atten := metric{"actiontec_line_attenuation_db", "Line attenuation in dB.", gauge, nil}
+	retrains := metric{"actiontec_line_retrains_total", "Line retrains.", counter, nil}
+	uptime := metric{"actiontec_line_uptime_seconds", "Line uptime in seconds.", gauge, nil}
+	state := metric{"actiontec_line_state", "Line state; the current state has the value 1.", gauge, nil}
+
+	for i, line := range sample.Lines {
+		l := labels{"line": strconv.Itoa(i)}
+
+		rate.values = append(rate.values, pairValues(l, float64(line.Rates.Up), float64(line.Rates.Down))...)
+		snr.values = append(snr.values, pairValues(l, float64(line.SignalNoiseMargin.Up), float64(line.SignalNoiseMargin.Down))...)
+		atten.values = append(atten.values, pairValues(l, line.Attenuation.Up, line.Attenuation.Down)...)
+		retrains.values = append(retrains.values, value{l, float64(line.Retrains)})
+		uptime.values = append(uptime.values, value{l, line.Uptime.Seconds()})
+
+		for _, s := range []actiontec.State{actiontec.Up, actiontec.EstablishingLink, actiontec.Down} {
+			v := 0.0
+			if s == line.State {
+				v = 1.0
+			}
+			state.values = append(state.values, value{labels{"line": l["line"], "state": stateName(s)}, v})
+		}
+	}
+
+	return append(metrics, rate, snr, atten, retrains, uptime, state)
+}
+
+func eventsToMetric(events map[eventKey]uint64) metric {
+	keys := make([]eventKey, 0, len(events))
+	for k := range events {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].router != keys[j].router {
+			return keys[i].router < keys[j].router
+		}
+		return keys[i].typ < keys[j].typ
+	})
+
+	m := metric{"actiontec_events_total", "Events emitted by the collector, by type.", counter, nil}
+	for _, k := range keys {
+		m.values = append(m.values, value{labels{"router": k.router, "type": k.typ}, float64(events[k])})
+	}
+
+	return m
+}
+
+// Returns up and down values with a direction label added to the given
+// labels.
+func pairValues(l labels, up, down float64) []value {
+	return []value{
+		{l.with("direction", "up"), up},
+		{l.with("direction", "down"), down},
+	}
+}
+
+func endValues(counters actiontec.EndCounters) []value {
+	return []value{
+		{labels{"end": "near", "channel": "interleaved"}, float64(counters.Near.Interleaved)},
+		{labels{"end": "near", "channel": "fast"}, float64(counters.Near.Fast)},
+		{labels{"end": "far", "channel": "interleaved"}, float64(counters.Far.Interleaved)},
+		{labels{"end": "far", "channel": "fast"}, float64(counters.Far.Fast)},
+	}
+}
+
+func nearFarValues(pair actiontec.NearFarPair) []value {
+	return []value{
+		{labels{"end": "near"}, float64(pair.Near)},
+		{labels{"end": "far"}, float64(pair.Far)},
+	}
+}
+
+func channelTypeName(ct actiontec.ChannelType) string {
+	if ct == actiontec.FastChannel {
+		return "fast"
+	}
+	return "interleaved"
+}
+
+func stateName(s actiontec.State) string {
+	switch s {
+	case actiontec.Up:
+		return "up"
+	case actiontec.EstablishingLink:
+		return "establishing_link"
+	}
+	return "down"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// The collector's own health. Durations are exposed as a total and a count, so
+// that rate() of one over the other gives the average.
+func healthToMetrics(s *health.Snapshot) []metric {
+	if s == nil {
+		return nil
+	}
+
+	var metrics []metric
+	timing := func(prefix, what string, l labels, t health.Timing) {
+		metrics = append(metrics,
+			metric{prefix + "_total", "Number of " + what + " attempts.", counter, []value{{l, float64(t.Count)}}},
+			metric{prefix + "_failures_total", "Number of failed " + what + " attempts.", counter, []value{{l, float64(t.Failures)}}},
+			metric{prefix + "_seconds_total", "Total time spent on " + what + " attempts.", counter, []value{{l, t.Total.Seconds()}}},
+		)
+	}
+	lastSuccess := func(name string, l labels, t time.Time) {
+		if !t.IsZero() {
+			metrics = append(metrics, metric{name, "When the last success was, as a Unix timestamp.", gauge, []value{{l, float64(t.UnixNano()) / 1e9}}})
+		}
+	}
+
+	for _, name := range s.RouterNames() {
+		rs := s.Routers[name]
+		l := labels{"router": name}
+
+		timing("actiontec_collector_login", "router login", l, rs.Login)
+		timing("actiontec_collector_scrape", "router status scrape", l, rs.Scrape)
+		metrics = append(metrics, metric{"actiontec_collector_parse_errors_total", "Router status payloads that couldn't be parsed.", counter, []value{{l, float64(rs.ParseErrors)}}})
+		lastSuccess("actiontec_collector_last_success_timestamp_seconds", l, rs.LastSuccess)
+	}
+
+	for _, name := range s.SinkNames() {
+		ss := s.Sinks[name]
+		l := labels{"sink": name}
+
+		timing("actiontec_sink_send", "sink send", l, ss.Send)
+		if ss.SpoolDepth >= 0 {
+			metrics = append(metrics, metric{"actiontec_sink_spool_depth", "Batches waiting in the spool to be delivered.", gauge, []value{{l, float64(ss.SpoolDepth)}}})
+		}
+		lastSuccess("actiontec_sink_last_success_timestamp_seconds", l, ss.LastSuccess)
+	}
+
+	return metrics
+}
+
+// The router label is added to everything later, but the host and any user
+// defined tags only go on the info metric, to keep the cardinality of
+// everything else down. They can be joined on in queries if needed.
+func infoLabels(sample *sink.Sample) labels {
+	l := labels{
+		"software_version": sample.Status.SoftwareVersion,
+		"channel_type":     channelTypeName(sample.Status.ChannelType),
+	}
+	if sample.Host != "" {
+		l["host"] = sample.Host
+	}
+	for k, v := range sample.Tags {
+		l["tag_"+labelNameSanitiser.ReplaceAllString(k, "_")] = v
+	}
+
+	return l
+}
+
+// Label names can only contain letters, digits and underscores.
+var labelNameSanitiser = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// Returns a copy of the labels with the given label added.
+func (l labels) with(name, val string) labels {
+	nl := labels{name: val}
+	for k, v := range l {
+		nl[k] = v
+	}
+
+	return nl
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Labels are rendered in sorted order so that the output is stable.
+func (l labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", k, labelEscaper.Replace(l[k]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,134 @@
+package prometheus
+
+import (
+	"actiontec"
+	"bytes"
+	"health"
+	"sink"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	sample := &sink.Sample{
+		Source: sink.Source{Router: "home", Host: "192.168.0.1", Tags: map[string]string{"site-name": "attic"}},
+		Time:   time.Now(),
+		Status: &actiontec.Status{
+			TotalRate:       actiontec.Rates{Up: 2000, Down: 10000},
+			SoftwareVersion: "T2200H-31.128L.03",
+			TotalRetrains:   3,
+			Failures:        actiontec.LinkFailures{Power: 1, Signal: 2, Margin: 3, Train: 4},
+			ModemUptime:     time.Duration(1000) * time.Second,
+			Packets: actiontec.PacketPair{
+				Received:    actiontec.Packets{Count: 100, Errors: 1},
+				Transmitted: actiontec.Packets{Count: 200, Errors: 2},
+			},
+			Errors: actiontec.LineErrors{
+				CRC: actiontec.EndCounters{Near: actiontec.PathCounters{Interleaved: 12}},
+				HEC: actiontec.NearFarPair{Near: 5, Far: 6},
+			},
+		},
+		Lines: []actiontec.LineStats{
+			{
+				State:             actiontec.Up,
+				Rates:             actiontec.Rates{Up: 1000, Down: 5000},
+				SignalNoiseMargin: actiontec.UintPair{Up: 7, Down: 9},
+				Attenuation:       actiontec.FloatPair{Up: 13.1, Down: 26.6},
+				Retrains:          2,
+				Uptime:            time.Duration(500) * time.Second,
+			},
+			{
+				State:    actiontec.Down,
+				Retrains: 1,
+			},
+		},
+	}
+
+	buffer := new(bytes.Buffer)
+	samples := map[string]*sink.Sample{"home": sample, "work": nil}
+	events := map[eventKey]uint64{{"work", "RouterUnreachable"}: 2}
+	snapshot := &health.Snapshot{
+		Routers: map[string]health.RouterStats{
+			"home": {
+				Login:       health.Timing{Count: 3, Failures: 1, Total: 1500 * time.Millisecond},
+				ParseErrors: 2,
+				LastSuccess: time.Unix(1500000000, 0),
+			},
+		},
+		Sinks: map[string]health.SinkStats{
+			"insights": {Send: health.Timing{Count: 4}, SpoolDepth: 7},
+			"influx":   {SpoolDepth: -1},
+		},
+	}
+	if err := writeMetrics(buffer, samples, events, snapshot); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+	output := buffer.String()
+
+	expected := []string{
+		`actiontec_up{router="home"} 1` + "\n",
+		`actiontec_up{router="work"} 0` + "\n",
+		"# TYPE actiontec_modem_retrains_total counter\n",
+		`actiontec_modem_info{channel_type="interleaved",host="192.168.0.1",router="home",software_version="T2200H-31.128L.03",tag_site_name="attic"} 1` + "\n",
+		`actiontec_modem_rate_kbps{direction="down",router="home"} 10000` + "\n",
+		`actiontec_modem_link_failures_total{router="home",type="train"} 4` + "\n",
+		`actiontec_modem_packet_errors_total{direction="transmitted",router="home"} 2` + "\n",
+		`actiontec_modem_uptime_seconds{router="home"} 1000` + "\n",
+		`actiontec_modem_crc_errors_total{channel="interleaved",end="near",router="home"} 12` + "\n",
+		`actiontec_modem_hec_errors_total{end="far",router="home"} 6` + "\n",
+		`actiontec_line_rate_kbps{direction="up",line="0",router="home"} 1000` + "\n",
+		`actiontec_line_snr_margin_db{direction="down",line="0",router="home"} 9` + "\n",
+		`actiontec_line_attenuation_db{direction="up",line="0",router="home"} 13.1` + "\n",
+		`actiontec_line_retrains_total{line="1",router="home"} 1` + "\n",
+		`actiontec_line_state{line="0",router="home",state="up"} 1` + "\n",
+		`actiontec_line_state{line="1",router="home",state="up"} 0` + "\n",
+		`actiontec_line_state{line="1",router="home",state="down"} 1` + "\n",
+		`actiontec_events_total{router="work",type="RouterUnreachable"} 2` + "\n",
+		`actiontec_collector_login_total{router="home"} 3` + "\n",
+		`actiontec_collector_login_failures_total{router="home"} 1` + "\n",
+		`actiontec_collector_login_seconds_total{router="home"} 1.5` + "\n",
+		`actiontec_collector_parse_errors_total{router="home"} 2` + "\n",
+		`actiontec_collector_last_success_timestamp_seconds{router="home"} 1.5e+09` + "\n",
+		`actiontec_sink_send_total{sink="insights"} 4` + "\n",
+		`actiontec_sink_spool_depth{sink="insights"} 7` + "\n",
+	}
+
+	if strings.Count(output, "# TYPE actiontec_up gauge\n") != 1 {
+		t.Errorf("Expected actiontec_up to be declared exactly once; got:\n%s", output)
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("Expected output to contain %q; got:\n%s", e, output)
+		}
+	}
+}
+
+func TestWriteMetricsNoSample(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	if err := writeMetrics(buffer, map[string]*sink.Sample{"home": nil}, nil, nil); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if !strings.HasSuffix(buffer.String(), "\nactiontec_up{router=\"home\"} 0\n") {
+		t.Errorf("Unexpected output: %s", buffer.String())
+	}
+}
+
+func TestLabelsString(t *testing.T) {
+	cases := []struct {
+		input    labels
+		expected string
+	}{
+		{nil, ""},
+		{labels{"b": "2", "a": "1"}, `{a="1",b="2"}`},
+		{labels{"a": "\"quoted\"\\"}, `{a="\"quoted\"\\"}`},
+	}
+
+	for _, c := range cases {
+		if s := c.input.String(); s != c.expected {
+			t.Errorf("Invalid labels: got %s; expected %s", s, c.expected)
+		}
+	}
+}
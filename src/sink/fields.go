@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"actiontec"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A named value, for sinks that need to flatten things into key/value pairs.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Flattens the modem wide parts of a status into fields. Nothing here is
+// maintained by hand: the fields are derived from the Status struct, so
+// anything new that ParseStatus learns to parse shows up automatically.
+//
+// Nested structs are flattened by joining the field names, so
+// Failures.Power becomes FailuresPower. Enums are rendered as their names,
+// durations as whole seconds, and slices are left out.
+func StatusFields(status *actiontec.Status) []Field {
+	return flatten("", reflect.ValueOf(*status), nil)
+}
+
+// Flattens the stats for a single line into fields, the same way StatusFields
+// does.
+func LineStatsFields(stats *actiontec.LineStats) []Field {
+	return flatten("", reflect.ValueOf(*stats), nil)
+}
+
+// A handful of fields get different names, mostly so that the event schema
+// stays compatible with what was sent before these were derived from the
+// structs. An empty name means the field's own name is left out of the
+// flattened names of its children.
+var fieldNames = map[string]string{
+	"LineStats.Rates":      "Rate",
+	"Status.TotalRate":     "Rate",
+	"Status.TotalRetrains": "Retrains",
+	"Status.Errors":        "",
+	"Packets.Count":        "",
+}
+
+// Status carries line 0's stats too, but every line gets its own set of
+// fields, so there's no point sending them twice. The software version is
+// sent with the router's identity, rather than as a field.
+var skippedFields = map[string]bool{
+	"Status.LineStats":       true,
+	"Status.SoftwareVersion": true,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func flatten(prefix string, v reflect.Value, fields []Field) []Field {
+	if v.Type() == durationType {
+		return append(fields, Field{prefix, int64(time.Duration(v.Int()).Seconds())})
+	} else if s, ok := v.Interface().(fmt.Stringer); ok {
+		return append(fields, Field{prefix, s.String()})
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := t.Name() + "." + f.Name
+			if f.PkgPath != "" || skippedFields[key] {
+				continue
+			}
+
+			name, ok := fieldNames[key]
+			if !ok && !f.Anonymous {
+				name = f.Name
+			}
+			fields = flatten(prefix+name, v.Field(i), fields)
+		}
+
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Ptr, reflect.Interface:
+		// Nothing that can be sensibly flattened.
+
+	default:
+		fields = append(fields, Field{prefix, v.Interface()})
+	}
+
+	return fields
+}
+
+// Flattens a delta into fields, with a per second rate alongside each count.
+// Sinks emit these as a ModemDeltas event or measurement.
+func DeltaFields(d *actiontec.Delta) []Field {
+	fields := []Field{
+		{"Interval", d.Interval.Seconds()},
+		{"Reset", d.Reset},
+		{"LineReset", d.LineReset},
+	}
+
+	add := func(name string, count uint64) {
+		fields = append(fields, Field{name, count}, Field{name + "PerSecond", d.PerSecond(count)})
+	}
+
+	add("PacketsReceived", d.Packets.Received.Count)
+	add("PacketErrorsReceived", d.Packets.Received.Errors)
+	add("PacketsTransmitted", d.Packets.Transmitted.Count)
+	add("PacketErrorsTransmitted", d.Packets.Transmitted.Errors)
+	add("FailuresPower", d.Failures.Power)
+	add("FailuresSignal", d.Failures.Signal)
+	add("FailuresMargin", d.Failures.Margin)
+	add("FailuresTrain", d.Failures.Train)
+	add("Retrains", d.Retrains)
+	add("UnavailableSeconds", uint64(d.UnavailableSeconds.Seconds()))
+	add("CRCNear", d.CRC.Near.Interleaved+d.CRC.Near.Fast)
+	add("CRCFar", d.CRC.Far.Interleaved+d.CRC.Far.Fast)
+	add("FECNear", d.FEC.Near.Interleaved+d.FEC.Near.Fast)
+	add("FECFar", d.FEC.Far.Interleaved+d.FEC.Far.Fast)
+	add("HECNear", d.HEC.Near)
+	add("HECFar", d.HEC.Far)
+	add("ErroredSecondsNear", d.ErroredSeconds.Near)
+	add("ErroredSecondsFar", d.ErroredSeconds.Far)
+	add("SeverelyErroredSecondsNear", d.SeverelyErroredSeconds.Near)
+	add("SeverelyErroredSecondsFar", d.SeverelyErroredSeconds.Far)
+
+	return fields
+}
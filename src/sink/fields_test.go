@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"actiontec"
+	"testing"
+	"time"
+)
+
+func TestStatusFields(t *testing.T) {
+	status := &actiontec.Status{
+		TotalRate:          actiontec.Rates{Up: 2000, Down: 10000},
+		SoftwareVersion:    "T2200H-31.128L.03",
+		LineStats:          actiontec.LineStats{Retrains: 5},
+		TotalRetrains:      3,
+		Failures:           actiontec.LinkFailures{Train: 4},
+		UnavailableSeconds: time.Duration(30) * time.Second,
+		ChannelType:        actiontec.FastChannel,
+		ModemUptime:        time.Duration(1000) * time.Second,
+		Packets: actiontec.PacketPair{
+			Received: actiontec.Packets{Count: 100, Errors: 1},
+		},
+		Errors: actiontec.LineErrors{
+			CRC: actiontec.EndCounters{Far: actiontec.PathCounters{Fast: 12}},
+			HEC: actiontec.NearFarPair{Near: 5},
+		},
+		LineRates: []actiontec.LineRate{{}},
+	}
+
+	fields := make(map[string]interface{})
+	for _, f := range StatusFields(status) {
+		if _, ok := fields[f.Name]; ok {
+			t.Errorf("Duplicate field: %s", f.Name)
+		}
+		fields[f.Name] = f.Value
+	}
+
+	for name, expected := range map[string]interface{}{
+		"RateUp":                uint64(2000),
+		"RateDown":              uint64(10000),
+		"Retrains":              uint64(3),
+		"FailuresTrain":         uint64(4),
+		"UnavailableSeconds":    int64(30),
+		"ChannelType":           "Fast",
+		"ModemUptime":           int64(1000),
+		"PacketsReceived":       uint64(100),
+		"PacketsReceivedErrors": uint64(1),
+		"CRCFarFast":            uint64(12),
+		"CRC30MinuteNearFast":   uint64(0),
+		"HECNear":               uint64(5),
+	} {
+		if fields[name] != expected {
+			t.Errorf("Expected %s to be %v (%T); got %v (%T)", name, expected, expected, fields[name], fields[name])
+		}
+	}
+
+	for _, name := range []string{"SoftwareVersion", "LineStatsRetrains", "LineRates"} {
+		if _, ok := fields[name]; ok {
+			t.Errorf("Didn't expect field %s", name)
+		}
+	}
+}
+
+func TestLineStatsFields(t *testing.T) {
+	stats := &actiontec.LineStats{
+		State:       actiontec.EstablishingLink,
+		Rates:       actiontec.Rates{Up: 1000},
+		Attenuation: actiontec.FloatPair{Down: 26.6},
+		Uptime:      time.Duration(90) * time.Second,
+	}
+
+	expected := []Field{
+		{"State", "EstablishingLink"},
+		{"RateUp", uint64(1000)},
+		{"RateDown", uint64(0)},
+		{"SignalNoiseMarginUp", uint64(0)},
+		{"SignalNoiseMarginDown", uint64(0)},
+		{"AttenuationUp", float64(0)},
+		{"AttenuationDown", 26.6},
+		{"Retrains", uint64(0)},
+		{"Uptime", int64(90)},
+	}
+
+	fields := LineStatsFields(stats)
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected %d fields; got %v", len(expected), fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("Field %d: expected %v; got %v", i, expected[i], fields[i])
+		}
+	}
+}
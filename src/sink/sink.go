@@ -0,0 +1,121 @@
+package sink
+
+// Sinks are where collected data ends up. Originally main.go just shoved
+// everything at New Relic Insights; now anything that can accept a sample can
+// be plugged in, and several can be enabled at once.
+
+import (
+	"actiontec"
+	"io"
+	"strings"
+	"time"
+)
+
+// Where a sample or event came from: the configured name of the router, its
+// host name or address, and any user defined tags.
+type Source struct {
+	Router string
+	Host   string
+	Tags   map[string]string
+}
+
+// A single collection from the router: the overall status, plus the stats for
+// each bonded line, indexed by line number. Delta is the change in the
+// cumulative counters since the previous sample from the same router, if there
+// was one.
+type Sample struct {
+	Source
+	Time   time.Time
+	Status *actiontec.Status
+	Lines  []actiontec.LineStats
+	Delta  *actiontec.Delta
+}
+
+// Something that happened, as opposed to a measurement: the router being
+// unreachable, for instance. Type is used as the event type or measurement
+// name by sinks that have such a thing. Attribute values should be strings,
+// bools, or numbers.
+type Event struct {
+	Source
+	Time       time.Time
+	Type       string
+	Attributes map[string]interface{}
+}
+
+// Anything that wants samples needs to implement this. Each router is
+// collected from in its own goroutine, so Send and SendEvent must be safe for
+// concurrent use.
+//
+// Sinks that hold onto anything (open files, undelivered data, listeners)
+// should also implement io.Closer, and flush or release it there. Close is
+// called when the process shuts down or reloads its configuration, after the
+// last sample has been sent.
+type Sink interface {
+	Send(sample *Sample) error
+	SendEvent(event *Event) error
+}
+
+// A group of sinks that are all sent the same sample. A failure in one sink
+// doesn't stop the sample being sent to the rest.
+type Multi []Sink
+
+func (m Multi) Send(sample *Sample) error {
+	var errs Errors
+
+	for _, s := range m {
+		if err := s.Send(sample); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (m Multi) SendEvent(event *Event) error {
+	var errs Errors
+
+	for _, s := range m {
+		if err := s.SendEvent(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Close every sink that implements io.Closer. As with Send, a failure in one
+// doesn't stop the rest being closed.
+func (m Multi) Close() error {
+	var errs Errors
+
+	for _, s := range m {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// The errors returned by the individual sinks within a Multi.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,191 @@
+package spool
+
+// A bounded on-disk queue of opaque batches, for holding onto data that
+// couldn't be delivered until it can be. Losing the WAN is exactly when we most
+// want to keep the data about it.
+//
+// Each batch is a file in the spool directory, named for its sequence number so
+// that a directory listing gives the order. Files are written under a
+// temporary name and renamed into place, so a crash mid-write doesn't leave a
+// truncated batch behind.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const batchSuffix = ".batch"
+const tempSuffix = ".tmp"
+
+type Spool struct {
+	dir string
+	max int
+
+	// Held for the whole of a drain, so that two drains can't send the same
+	// batch. mu is only held while the list of batches is being looked at or
+	// changed, so that pushing (and Len) never wait on a send.
+	draining sync.Mutex
+
+	mu      sync.Mutex
+	batches []uint64
+	next    uint64
+}
+
+// Open (creating if needed) a spool in dir that holds at most max batches.
+// Once full, the oldest batch is dropped to make room for each new one.
+func Open(dir string, max int) (*Spool, error) {
+	if max < 1 {
+		return nil, fmt.Errorf("Spool size must be at least 1; got %d", max)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, max: max}
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Leftovers from a write that didn't finish.
+		if strings.HasSuffix(name, tempSuffix) {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		if !strings.HasSuffix(name, batchSuffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.batches = append(s.batches, seq)
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Slice(s.batches, func(i, j int) bool { return s.batches[i] < s.batches[j] })
+
+	return s, nil
+}
+
+// The number of batches waiting.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.batches)
+}
+
+// Add a batch to the end of the queue. Returns the number of old batches that
+// had to be dropped to make room.
+func (s *Spool) Push(data []byte) (dropped int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.next
+	temp := s.path(seq) + tempSuffix
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		os.Remove(temp)
+		return
+	}
+
+	if err = os.Rename(temp, s.path(seq)); err != nil {
+		os.Remove(temp)
+		return
+	}
+
+	s.next++
+	s.batches = append(s.batches, seq)
+
+	for len(s.batches) > s.max {
+		if err = os.Remove(s.path(s.batches[0])); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+		s.batches = s.batches[1:]
+		dropped++
+	}
+
+	return
+}
+
+// Hand up to max batches (or all of them, if max is zero) to send, oldest
+// first, removing each once send succeeds. Stops at the first error, which is
+// returned; that batch stays at the front of the queue.
+//
+// The spool isn't locked while send runs, so batches can be pushed meanwhile.
+// If the spool fills up and the batch being sent is dropped to make room, it's
+// been sent anyway, so no harm done.
+func (s *Spool) Drain(max int, send func(data []byte) error) error {
+	s.draining.Lock()
+	defer s.draining.Unlock()
+
+	s.mu.Lock()
+	batches := append([]uint64(nil), s.batches...)
+	s.mu.Unlock()
+
+	if max > 0 && len(batches) > max {
+		batches = batches[:max]
+	}
+
+	for _, seq := range batches {
+		data, err := ioutil.ReadFile(s.path(seq))
+		if os.IsNotExist(err) {
+			// Dropped to make room since we looked, or someone's been tidying up.
+			// Nothing to send, anyway.
+			if err := s.remove(seq); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := send(data); err != nil {
+			return err
+		}
+
+		if err := s.remove(seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Removes a batch that's been dealt with, if Push hasn't already dropped it.
+func (s *Spool) remove(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i, b := range s.batches {
+		if b == seq {
+			s.batches = append(s.batches[:i:i], s.batches[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, batchSuffix))
+}
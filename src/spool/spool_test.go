@@ -0,0 +1,152 @@
+package spool
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func drainAll(t *testing.T, s *Spool) []string {
+	var got []string
+	if err := s.Drain(0, func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	}); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	return got
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		data    string
+		dropped int
+	}{
+		{"a", 0},
+		{"b", 0},
+		{"c", 0},
+		{"d", 1},
+	} {
+		dropped, err := s.Push([]byte(c.data))
+		if err != nil {
+			t.Fatalf("Got an error when one wasn't expected: %v", err)
+		}
+
+		if dropped != c.dropped {
+			t.Errorf("Pushing %s: dropped %d; expected %d", c.data, dropped, c.dropped)
+		}
+	}
+
+	if s.Len() != 3 {
+		t.Errorf("Unexpected length: %d", s.Len())
+	}
+
+	// A failed send leaves the batch at the front.
+	sendErr := errors.New("nope")
+	calls := 0
+	if err := s.Drain(0, func(data []byte) error {
+		calls++
+		if string(data) == "c" {
+			return sendErr
+		}
+		return nil
+	}); err != sendErr {
+		t.Errorf("Expected the send error; got %v", err)
+	}
+
+	if calls != 2 || s.Len() != 2 {
+		t.Errorf("Unexpected state after failed drain: %d calls, %d batches", calls, s.Len())
+	}
+
+	// Reopening picks up where we left off, and ignores temporary files.
+	ioutil.WriteFile(filepath.Join(dir, "junk.tmp"), []byte("x"), 0600)
+	s, err = Open(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Push([]byte("e")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := drainAll(t, s)
+	if len(got) != 3 || got[0] != "c" || got[1] != "d" || got[2] != "e" {
+		t.Errorf("Unexpected batches: %v", got)
+	}
+
+	if s.Len() != 0 {
+		t.Errorf("Expected an empty spool; got %d batches", s.Len())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "junk.tmp")); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary file to be removed")
+	}
+}
+
+func TestDrainLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"a", "b", "c"} {
+		s.Push([]byte(data))
+	}
+
+	// Pushing and Len don't wait on a send in progress.
+	var got []string
+	if err := s.Drain(2, func(data []byte) error {
+		got = append(got, string(data))
+
+		done := make(chan int)
+		go func() {
+			s.Push([]byte("d"))
+			done <- s.Len()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Push blocked on the drain")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Got an error when one wasn't expected: %v", err)
+	}
+
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Unexpected batches: %v", got)
+	}
+
+	got = drainAll(t, s)
+	if len(got) != 3 || got[0] != "c" || got[1] != "d" || got[2] != "d" {
+		t.Errorf("Unexpected batches: %v", got)
+	}
+}
+
+func TestOpenInvalidSize(t *testing.T) {
+	if _, err := Open(os.TempDir(), 0); err == nil {
+		t.Errorf("Expected an error; got none")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"actiontec"
+	"detect"
+	"sink"
+)
+
+// State carried between samples from a single router: the previous sample,
+// for calculating deltas, and the event detector.
+type tracker struct {
+	previous *sink.Sample
+	detector *detect.Detector
+}
+
+func newTracker() *tracker {
+	return &tracker{detector: detect.New()}
+}
+
+// Fill in the sample's delta, and return any events it generates.
+func (t *tracker) process(sample *sink.Sample) []*sink.Event {
+	if t.previous != nil {
+		sample.Delta = actiontec.Diff(t.previous.Status, sample.Status, sample.Time.Sub(t.previous.Time))
+	}
+	t.previous = sample
+
+	return t.detector.Observe(sample)
+}